@@ -0,0 +1,158 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build appengine
+
+package app
+
+import (
+	"appengine"
+	"appengine/user"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// accessRule restricts the packages with import paths starting with Prefix
+// to the listed users and groups. A user entry of the form "@example.com"
+// matches all users in the domain.
+type accessRule struct {
+	Prefix string
+	Users  []string
+	Groups []string
+}
+
+// accessRules is the list of rules loaded from the file named by the
+// ACCESS_RULES environment variable. Packages that do not match a rule are
+// public unless the package is private.
+var accessRules []*accessRule
+
+// Reverse proxy identity configuration. When userHeader is set, the
+// authenticating proxy in front of the application (an OIDC proxy, for
+// example) passes the user's email address and comma separated groups in the
+// named request headers. The proxy must also send proxySecret in the
+// X-Proxy-Secret header so that the headers cannot be set by clients that
+// bypass the proxy.
+var (
+	userHeader   = os.Getenv("ACCESS_USER_HEADER")
+	groupsHeader = os.Getenv("ACCESS_GROUPS_HEADER")
+	proxySecret  = os.Getenv("ACCESS_PROXY_SECRET")
+)
+
+func loadAccessRules(filename string) error {
+	p, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, &accessRules)
+}
+
+// findAccessRule returns the rule with the longest prefix matching importPath
+// or nil if no rule matches.
+func findAccessRule(importPath string) *accessRule {
+	var result *accessRule
+	for _, r := range accessRules {
+		prefix := strings.TrimSuffix(r.Prefix, "/")
+		if importPath != prefix && !strings.HasPrefix(importPath, prefix+"/") {
+			continue
+		}
+		if result == nil || len(r.Prefix) > len(result.Prefix) {
+			result = r
+		}
+	}
+	return result
+}
+
+// viewer is the identity of the user making a request.
+type viewer struct {
+	email  string
+	groups []string
+	admin  bool
+}
+
+// currentViewer returns the identity of the user making the request. The
+// identity is taken from the reverse proxy headers when configured and from
+// the App Engine users service otherwise.
+func currentViewer(c appengine.Context, r *http.Request) *viewer {
+	v := &viewer{admin: user.IsAdmin(c)}
+	if userHeader != "" {
+		if proxySecret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Proxy-Secret")), []byte(proxySecret)) != 1 {
+			return v
+		}
+		v.email = strings.ToLower(strings.TrimSpace(r.Header.Get(userHeader)))
+		if groupsHeader != "" {
+			for _, g := range strings.Split(r.Header.Get(groupsHeader), ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					v.groups = append(v.groups, g)
+				}
+			}
+		}
+	} else if u := user.Current(c); u != nil {
+		v.email = strings.ToLower(u.Email)
+	}
+	for _, email := range adminUsers {
+		if v.email != "" && v.email == email {
+			v.admin = true
+		}
+	}
+	return v
+}
+
+// allowed returns true if the viewer is listed in the rule.
+func (v *viewer) allowed(rule *accessRule) bool {
+	if v.email == "" {
+		return false
+	}
+	for _, u := range rule.Users {
+		u = strings.ToLower(u)
+		if u == v.email || (strings.HasPrefix(u, "@") && strings.HasSuffix(v.email, u)) {
+			return true
+		}
+	}
+	for _, g := range rule.Groups {
+		for _, vg := range v.groups {
+			if g == vg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canView returns true if the viewer can view the package with importPath.
+// Private packages that do not match a rule are shown to administrators
+// only.
+func (v *viewer) canView(importPath string, private bool) bool {
+	if v.admin {
+		return true
+	}
+	if rule := findAccessRule(importPath); rule != nil {
+		return v.allowed(rule)
+	}
+	return !private
+}
+
+// filter removes the packages that the viewer cannot view from pkgs.
+func (v *viewer) filter(pkgs []*Package) []*Package {
+	out := pkgs[0:0]
+	for _, pkg := range pkgs {
+		if v.canView(pkg.ImportPath, pkg.Private) {
+			out = append(out, pkg)
+		}
+	}
+	return out
+}
@@ -0,0 +1,451 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build appengine
+
+package app
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+	"appengine/user"
+	"crypto/subtle"
+	"doc"
+	"encoding/gob"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminUsers is the list of email addresses of administrators identified by
+// the reverse proxy headers. App Engine administrators are always
+// administrators.
+var adminUsers = strings.Fields(strings.ToLower(os.Getenv("ADMIN_USERS")))
+
+// adminAPIToken authenticates scripts that call the admin API. Requests with
+// the token in the X-Admin-Token header are administrator requests and are not
+// checked for the origin and CSRF token. Scripts are disabled if the token is
+// "".
+var adminAPIToken = os.Getenv("ADMIN_API_TOKEN")
+
+// apiViewer returns the viewer for a request with the admin API token or nil
+// if the request does not have the token.
+func apiViewer(r *http.Request) *viewer {
+	if adminAPIToken == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(adminAPIToken)) != 1 {
+		return nil
+	}
+	return &viewer{email: "api", admin: true}
+}
+
+const blockListKey = "blocklist"
+
+// Block prevents fetching of the import paths matching Entry. The entry is a
+// doc.Blocklist entry. The entity key name is the entry.
+type Block struct {
+	Entry   string `datastore:"-"`
+	Created time.Time
+	By      string `datastore:",noindex"`
+}
+
+// AuditEntry records an action taken by an administrator.
+type AuditEntry struct {
+	Time   time.Time
+	User   string `datastore:",noindex"`
+	Action string `datastore:",noindex"`
+	Target string `datastore:",noindex"`
+}
+
+// blockEntries returns the stored blocklist entries.
+func blockEntries(c appengine.Context) ([]string, error) {
+	var entries []string
+	item, err := cacheGet(c, blockListKey, &entries)
+	if err == memcache.ErrCacheMiss {
+		keys, err := datastore.NewQuery("Block").KeysOnly().GetAll(c, nil)
+		if err != nil {
+			return nil, err
+		}
+		entries = []string{}
+		for _, key := range keys {
+			entries = append(entries, key.StringID())
+		}
+		item.Expiration = time.Hour
+		item.Object = entries
+		if err := cacheSafeSet(c, item); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// loadedBlockEntries is the list of entries in the blocklist set in the doc
+// package.
+var (
+	loadedBlockEntries string
+	loadedBlockMutex   sync.Mutex
+)
+
+// loadBlocklist updates the blocklist in the doc package from the stored
+// entries.
+func loadBlocklist(c appengine.Context) error {
+	entries, err := blockEntries(c)
+	if err != nil {
+		return err
+	}
+	joined := strings.Join(entries, "\n")
+	loadedBlockMutex.Lock()
+	defer loadedBlockMutex.Unlock()
+	if joined == loadedBlockEntries {
+		return nil
+	}
+	valid := entries[0:0]
+	for _, e := range entries {
+		if _, err := doc.NewBlocklist([]string{e}); err != nil {
+			c.Errorf("Bad blocklist entry: %v", err)
+			continue
+		}
+		valid = append(valid, e)
+	}
+	b, err := doc.NewBlocklist(valid)
+	if err != nil {
+		return err
+	}
+	doc.SetBlocklist(b)
+	loadedBlockEntries = joined
+	return nil
+}
+
+// checkBlocked returns doc.ErrBlocked if importPath is on the stored
+// blocklist. The blocklist in the doc package is updated first so that the
+// doc package does not fetch blocked paths.
+func checkBlocked(c appengine.Context, importPath string) error {
+	if err := loadBlocklist(c); err != nil {
+		return err
+	}
+	if doc.IsBlocked(importPath) {
+		return doc.ErrBlocked
+	}
+	return nil
+}
+
+// audit records an administrator action.
+func audit(c appengine.Context, v *viewer, action, target string) {
+	e := AuditEntry{Time: time.Now(), User: v.email, Action: action, Target: target}
+	if _, err := datastore.Put(c, datastore.NewIncompleteKey(c, "AuditEntry", nil), &e); err != nil {
+		c.Errorf("audit(%s, %s) -> %v", action, target, err)
+	}
+	c.Infof("Admin %s: %s %s", v.email, action, target)
+}
+
+// checkAdmin returns the viewer if the viewer is an administrator. Otherwise,
+// checkAdmin redirects to the login page or writes an error and returns nil.
+func checkAdmin(w http.ResponseWriter, r *http.Request) (*viewer, error) {
+	c := appengine.NewContext(r)
+	v := currentViewer(c, r)
+	if v.admin {
+		return v, nil
+	}
+	if v.email == "" && userHeader == "" {
+		loginURL, err := user.LoginURL(c, r.URL.String())
+		if err != nil {
+			return nil, err
+		}
+		http.Redirect(w, r, loginURL, 302)
+		return nil, nil
+	}
+	http.Error(w, "Forbidden.", http.StatusForbidden)
+	return nil, nil
+}
+
+// adminHandlerFunc adapts a function to an http.Handler that requires an
+// administrator. Only GET and HEAD requests are accepted. Requests that
+// change state are handled by adminActionFunc.
+type adminHandlerFunc func(w http.ResponseWriter, r *http.Request, v *viewer) error
+
+func (f adminHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not supported.", http.StatusMethodNotAllowed)
+			return nil
+		}
+		v := apiViewer(r)
+		if v == nil {
+			var err error
+			if v, err = checkAdmin(w, r); v == nil {
+				return err
+			}
+		}
+		return f(w, r, v)
+	}).ServeHTTP(w, r)
+}
+
+// adminActionFunc adapts a function to an http.Handler for an administrator
+// action that changes state. Actions must be POSTs from the same origin with
+// the CSRF token set by the admin page or POSTs from scripts with the admin
+// API token.
+type adminActionFunc func(w http.ResponseWriter, r *http.Request, v *viewer) error
+
+func (f adminActionFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not supported.", http.StatusMethodNotAllowed)
+			return nil
+		}
+		if v := apiViewer(r); v != nil {
+			return f(w, r, v)
+		}
+		v, err := checkAdmin(w, r)
+		if v == nil {
+			return err
+		}
+		if !sameOrigin(r) || !validCSRFToken(r) {
+			http.Error(w, "Forbidden.", http.StatusForbidden)
+			return nil
+		}
+		return f(w, r, v)
+	}).ServeHTTP(w, r)
+}
+
+// sameOrigin returns true if the Origin or Referer header of the request
+// names the request host. Browsers send one of the headers with form posts.
+// Requests with neither header are refused.
+func sameOrigin(r *http.Request) bool {
+	s := r.Header.Get("Origin")
+	if s == "" {
+		s = r.Header.Get("Referer")
+	}
+	if s == "" {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && u.Host == r.Host
+}
+
+const csrfCookie = "csrf"
+
+// csrfToken returns the CSRF token for the session. A new token is stored in
+// a cookie if the request does not have one.
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && len(cookie.Value) == 32 {
+		return cookie.Value, nil
+	}
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: token, Path: "/", HttpOnly: true})
+	return token, nil
+}
+
+// validCSRFToken returns true if the csrf form value matches the session's
+// CSRF token.
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.FormValue("csrf")), []byte(cookie.Value)) == 1
+}
+
+// adminDone completes an admin API request. Form posts from the admin page
+// are redirected to the admin page.
+func adminDone(w http.ResponseWriter, r *http.Request, message string) error {
+	if r.FormValue("redirect") != "" {
+		http.Redirect(w, r, "/-/admin", 302)
+		return nil
+	}
+	_, err := io.WriteString(w, message+"\n")
+	return err
+}
+
+func serveAdmin(w http.ResponseWriter, r *http.Request, v *viewer) error {
+	c := appengine.NewContext(r)
+	token, err := csrfToken(w, r)
+	if err != nil {
+		return err
+	}
+	var blocks []*Block
+	keys, err := datastore.NewQuery("Block").Order("-Created").GetAll(c, &blocks)
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		blocks[i].Entry = keys[i].StringID()
+	}
+	var entries []*AuditEntry
+	if _, err := datastore.NewQuery("AuditEntry").Order("-Time").Limit(100).GetAll(c, &entries); err != nil {
+		return err
+	}
+	return executeTemplate(w, "admin.html", 200, map[string]interface{}{
+		"user":    v.email,
+		"csrf":    token,
+		"blocks":  blocks,
+		"entries": entries,
+	})
+}
+
+// setHidden sets the administrator hide flag on the package and updates the
+// package from the stored documentation.
+func setHidden(c appengine.Context, importPath string, hidden bool) error {
+	key := datastore.NewKey(c, "Package", importPath, 0, nil)
+	var pkg Package
+	err := datastore.Get(c, key, &pkg)
+	if err == datastore.ErrNoSuchEntity {
+		// Standard packages are stored with the key name "/" + importPath.
+		key = datastore.NewKey(c, "Package", "/"+importPath, 0, nil)
+		err = datastore.Get(c, key, &pkg)
+	}
+	if err != nil {
+		return err
+	}
+	var pdoc *doc.Package
+	if !hidden {
+		// Recompute the index fields from the stored documentation.
+		if pdoc, _, err = loadDoc(c, importPath); err != nil {
+			return err
+		}
+	}
+	pkg.HiddenByAdmin = hidden
+	switch {
+	case hidden:
+		pkg.Hide = true
+		pkg.IndexTokens = nil
+	case pdoc == nil:
+		// The index tokens are set when the package is fetched again.
+		pkg.Hide = false
+	}
+	if _, err := datastore.Put(c, key, &pkg); err != nil {
+		return err
+	}
+	if pdoc != nil {
+		return updatePackage(c, importPath, pdoc)
+	}
+	return cacheClear(c, packageListKey)
+}
+
+func serveAPIHide(w http.ResponseWriter, r *http.Request, v *viewer) error {
+	c := appengine.NewContext(r)
+	importPath := r.FormValue("importPath")
+	hidden := r.URL.Path == "/a/hide"
+	err := setHidden(c, importPath, hidden)
+	if err == datastore.ErrNoSuchEntity {
+		return adminDone(w, r, "no entity")
+	}
+	if err != nil {
+		return err
+	}
+	audit(c, v, r.URL.Path[len("/a/"):], importPath)
+	return adminDone(w, r, "ok")
+}
+
+// purgePackage removes the stored documentation, package and cached
+// documentation for importPath.
+func purgePackage(c appengine.Context, importPath string) error {
+	if err := memcache.Delete(c, docKeyPrefix+importPath); err != nil && err != memcache.ErrCacheMiss {
+		return err
+	}
+	return updatePackage(c, importPath, nil)
+}
+
+func serveAPIPurge(w http.ResponseWriter, r *http.Request, v *viewer) error {
+	c := appengine.NewContext(r)
+	importPath := r.FormValue("importPath")
+	if err := purgePackage(c, importPath); err != nil {
+		return err
+	}
+	audit(c, v, "purge", importPath)
+	return adminDone(w, r, "ok")
+}
+
+func serveAPIBlock(w http.ResponseWriter, r *http.Request, v *viewer) error {
+	c := appengine.NewContext(r)
+	entry := r.FormValue("entry")
+	if entry == "" {
+		kind := r.FormValue("kind")
+		pattern := strings.TrimSpace(r.FormValue("pattern"))
+		if kind != "regexp" {
+			pattern = strings.Trim(pattern, "/")
+			if !doc.ValidRemotePath(pattern) {
+				http.Error(w, "Bad host or import path.", http.StatusBadRequest)
+				return nil
+			}
+		}
+		entry = kind + ":" + pattern
+	}
+	if _, err := doc.NewBlocklist([]string{entry}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	key := datastore.NewKey(c, "Block", entry, 0, nil)
+	if r.URL.Path == "/a/unblock" {
+		if err := datastore.Delete(c, key); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+	} else {
+		if _, err := datastore.Put(c, key, &Block{Created: time.Now(), By: v.email}); err != nil {
+			return err
+		}
+	}
+	if err := cacheClear(c, blockListKey); err != nil {
+		return err
+	}
+	audit(c, v, r.URL.Path[len("/a/"):], entry)
+	return adminDone(w, r, "ok")
+}
+
+func serveAPIDump(w http.ResponseWriter, r *http.Request, v *viewer) error {
+	c := appengine.NewContext(r)
+	var pkgs []*Package
+	keys, err := datastore.NewQuery("Package").GetAll(c, &pkgs)
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		importPath := keys[i].StringID()
+		pkgs[i].ImportPath = importPath
+	}
+	return gob.NewEncoder(w).Encode(pkgs)
+}
+
+func serveAPILoad(w http.ResponseWriter, r *http.Request, v *viewer) error {
+	c := appengine.NewContext(r)
+	var pkgs []*Package
+	err := gob.NewDecoder(r.Body).Decode(&pkgs)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		key := datastore.NewKey(c, "Package", pkg.ImportPath, 0, nil)
+		if _, err := datastore.Put(c, key, pkg); err != nil {
+			c.Infof("%s %v", pkg.ImportPath, err)
+		}
+	}
+	err = memcache.Delete(c, packageListKey)
+	if err != nil {
+		c.Infof("clear %v", err)
+	}
+	audit(c, v, "load", "")
+	return nil
+}
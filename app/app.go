@@ -19,17 +19,23 @@ package app
 import (
 	"appengine"
 	"appengine/datastore"
+	"appengine/delay"
 	"appengine/memcache"
 	"appengine/urlfetch"
 	"bytes"
+	"context"
 	"doc"
-	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"regexp"
+	"run"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -51,7 +57,7 @@ func filterCmds(in []*Package) (out []*Package, cmds []*Package) {
 	return
 }
 
-func childPackages(c appengine.Context, projectRoot, importPath string) ([]*Package, error) {
+func childPackages(c appengine.Context, v *viewer, projectRoot, importPath string) ([]*Package, error) {
 	projectPkgs, err := queryPackages(c, projectListKeyPrefix+projectRoot,
 		datastore.NewQuery("Package").
 			Filter("__key__ >", datastore.NewKey(c, "Package", projectRoot+"/", 0, nil)).
@@ -67,20 +73,32 @@ func childPackages(c appengine.Context, projectRoot, importPath string) ([]*Pack
 			pkgs = append(pkgs, pkg)
 		}
 	}
-	return pkgs, nil
+	return v.filter(pkgs), nil
 }
 
-// getDoc gets the package documentation and child packages for the given import path.
-func getDoc(c appengine.Context, importPath string) (*doc.Package, []*Package, error) {
+// verifyExamples enables type checking of examples against the package and
+// the stored documentation for the package dependencies.
+var verifyExamples = os.Getenv("VERIFY_EXAMPLES") != ""
 
-	// 1. Look for doc in cache.
+// getDoc gets the package documentation and child packages for the given import
+// path. ErrPackageNotFound is returned if the viewer cannot view the package.
+func getDoc(c appengine.Context, v *viewer, importPath string) (*doc.Package, []*Package, error) {
+
+	// 1. Check the blocklist and look for doc in cache.
+
+	if err := checkBlocked(c, importPath); err != nil {
+		return nil, nil, err
+	}
 
 	cacheKey := docKeyPrefix + importPath
 	var pdoc *doc.Package
-	item, err := cacheGet(c, cacheKey, &pdoc)
+	_, err := cacheGet(c, cacheKey, &pdoc)
 	switch err {
 	case nil:
-		pkgs, err := childPackages(c, pdoc.ProjectRoot, importPath)
+		if !v.canView(importPath, pdoc.Private) {
+			return nil, nil, doc.ErrPackageNotFound
+		}
+		pkgs, err := childPackages(c, v, pdoc.ProjectRoot, importPath)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -91,60 +109,203 @@ func getDoc(c appengine.Context, importPath string) (*doc.Package, []*Package, e
 		return nil, nil, err
 	}
 
-	// 2. Look for doc in store.
+	// 2. Look for doc in store. The stored doc is served while the
+	// documentation is refreshed in the background.
 
 	pdocSaved, etag, err := loadDoc(c, importPath)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// 3. Get documentation from the version control service and update
-	// datastore and cache as needed.
+	if pdocSaved != nil {
+		startRefresh(c, importPath)
+		pdoc = pdocSaved
+	} else {
 
-	pdoc, err = doc.Get(urlfetch.Client(c), importPath, etag)
-	c.Infof("doc.Get(%q, %q) -> %v", importPath, etag, err)
+		// 3. Get documentation from the version control service unless a
+		// recent fetch failed.
 
-	switch err {
-	case nil:
-		if err := updatePackage(c, importPath, pdoc); err != nil {
+		if err := checkFetchFailure(c, importPath); err != nil {
 			return nil, nil, err
 		}
-		item.Object = pdoc
-		item.Expiration = time.Hour
-		if err := cacheSet(c, item); err != nil {
+		pdoc, err = fetchDoc(c, importPath, nil, etag)
+		if pdoc == nil {
 			return nil, nil, err
 		}
+	}
+
+	// 4. Hide packages from users who cannot view them.
+
+	if !v.canView(importPath, pdoc.Private) {
+		return nil, nil, doc.ErrPackageNotFound
+	}
+
+	// 5. Find the child packages.
+
+	pkgs, err := childPackages(c, v, pdoc.ProjectRoot, importPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 6. Convert to not found if package is empty.
+
+	if len(pkgs) == 0 && pdoc.Name == "" && len(pdoc.Errors) == 0 {
+		return nil, nil, doc.ErrPackageNotFound
+	}
+
+	// 7. Done
+
+	return pdoc, pkgs, nil
+}
+
+// fetchDoc gets documentation from the version control service and updates
+// the datastore and cache as needed. The saved documentation is returned if
+// the package is not modified. If there is an error fetching the package, the
+// saved documentation is cached for a short time and returned with the
+// error. Concurrent fetches of the same package are combined.
+func fetchDoc(c appengine.Context, importPath string, pdocSaved *doc.Package, etag string) (*doc.Package, error) {
+	return singleFetch(c, importPath, pdocSaved, func() (*doc.Package, error) {
+		return fetchDocOnce(c, importPath, pdocSaved, etag)
+	})
+}
+
+// fetchDeadline bounds the time spent getting documentation from the
+// version control service. Requests are stopped before App Engine's request
+// deadline.
+const fetchDeadline = 50 * time.Second
+
+// getFromService gets documentation from the version control service with
+// the stored blocklist. The fetch is stopped when the request of c is
+// canceled or the fetch deadline passes.
+func getFromService(c appengine.Context, importPath string, etag string) (*doc.Package, error) {
+	if err := checkBlocked(c, importPath); err != nil {
+		return nil, err
+	}
+	ctx, cancel := requestContext(c, fetchDeadline)
+	defer cancel()
+	pdoc, err := doc.GetContext(ctx, urlfetch.Client(c), importPath, etag)
+	if err != nil && ctx.Err() == context.Canceled {
+		// The client went away. The error is not a failure of the service
+		// and is not remembered.
+		return nil, ctx.Err()
+	}
+	return pdoc, err
+}
+
+func fetchDocOnce(c appengine.Context, importPath string, pdocSaved *doc.Package, etag string) (*doc.Package, error) {
+	pdoc, err := getFromService(c, importPath, etag)
+	c.Infof("doc.GetContext(%q, %q) -> %v", importPath, etag, err)
+	if pdocSaved == nil || err == nil || err == doc.ErrPackageNotFound {
+		recordFetchFailure(c, importPath, err)
+	}
+
+	switch err {
+	case nil:
+		if verifyExamples {
+			n := doc.VerifyExamples(pdoc, func(importPath string) (*doc.Package, error) {
+				pdoc, _, err := loadDoc(c, importPath)
+				return pdoc, err
+			})
+			c.Infof("doc.VerifyExamples(%q) -> %d broken", importPath, n)
+		}
+		if err := updatePackage(c, importPath, pdoc); err != nil {
+			return nil, err
+		}
 	case doc.ErrPackageNotFound:
 		if err := updatePackage(c, importPath, nil); err != nil {
-			return nil, nil, err
+			return nil, err
 		}
-		return nil, nil, doc.ErrPackageNotFound
+		return nil, doc.ErrPackageNotFound
 	case doc.ErrPackageNotModified:
 		pdoc = pdocSaved
+	case doc.ErrBlocked:
+		return nil, err
 	default:
 		if pdocSaved == nil {
-			return nil, nil, err
+			return nil, err
 		}
 		c.Errorf("Serving %s from store after error from VCS.", importPath)
-		pdoc = pdocSaved
-	}
 
-	// 4. Find the child packages.
+		// Cache the saved documentation so that page views do not read the
+		// store and start refreshes while the service is failing.
+		item := &memcache.Item{Key: docKeyPrefix + importPath, Object: pdocSaved, Expiration: refreshRetryDelay}
+		if err := cacheSet(c, item); err != nil {
+			c.Errorf("cacheSet(%s) -> %v", importPath, err)
+		}
+		return pdocSaved, err
+	}
 
-	pkgs, err := childPackages(c, pdoc.ProjectRoot, importPath)
-	if err != nil {
-		return nil, nil, err
+	item := &memcache.Item{Key: docKeyPrefix + importPath, Object: pdoc, Expiration: time.Hour}
+	if err := cacheSet(c, item); err != nil {
+		// Documentation for large packages does not fit in memcache.
+		// Serve these packages from the store.
+		c.Errorf("cacheSet(%s) -> %v", importPath, err)
 	}
+	return pdoc, nil
+}
 
-	// 5. Convert to not found if package is empty.
+const (
+	refreshKeyPrefix = "refresh:"
 
-	if len(pkgs) == 0 && pdoc.Name == "" && len(pdoc.Errors) == 0 {
-		return nil, nil, doc.ErrPackageNotFound
+	// Expiration of the refresh key while a refresh is in progress.
+	refreshExpiration = 10 * time.Minute
+
+	// Time before the next refresh after a refresh fails to get the
+	// documentation.
+	refreshRetryDelay = 10 * time.Minute
+)
+
+// refreshDocLater refreshes the stored documentation in a task.
+var refreshDocLater = delay.Func("refreshDoc", refreshDoc)
+
+// refreshDoc refreshes the stored documentation for importPath. The refresh
+// key is deleted when the refresh is done. Errors other than fetch errors are
+// returned so that the task is retried with the refresh key in place. When
+// fetching the package fails, the task gives up and the refresh key is kept
+// until the retry delay passes.
+func refreshDoc(c appengine.Context, importPath string) error {
+	key := refreshKeyPrefix + importPath
+	pdocSaved, etag, err := loadDoc(c, importPath)
+	if err != nil {
+		return err
 	}
+	_, err = fetchDoc(c, importPath, pdocSaved, etag)
+	if _, ok := err.(doc.GetError); ok {
+		c.Warningf("Refresh of %s failed: %v", importPath, err)
+		item := &memcache.Item{Key: key, Value: []byte{0}, Expiration: refreshRetryDelay}
+		if err := memcache.Set(c, item); err != nil {
+			c.Errorf("memcache.Set(%s) -> %v", key, err)
+		}
+		return nil
+	}
+	if err != nil && err != doc.ErrPackageNotFound && err != doc.ErrBlocked {
+		return err
+	}
+	if err := memcache.Delete(c, key); err != nil && err != memcache.ErrCacheMiss {
+		c.Errorf("memcache.Delete(%s) -> %v", key, err)
+	}
+	return nil
+}
 
-	// 6. Done
+// startRefresh starts a background refresh of the documentation for
+// importPath unless a refresh is in progress or a recent refresh failed.
+func startRefresh(c appengine.Context, importPath string) {
+	item := &memcache.Item{Key: refreshKeyPrefix + importPath, Value: []byte{1}, Expiration: refreshExpiration}
+	switch err := memcache.Add(c, item); err {
+	case nil:
+		refreshDocLater.Call(c, importPath)
+	case memcache.ErrNotStored:
+		// A refresh is in progress or failed recently.
+	default:
+		c.Errorf("memcache.Add(%s) -> %v", item.Key, err)
+	}
+}
 
-	return pdoc, pkgs, nil
+// isRefreshing returns true if a background refresh of the documentation for
+// importPath is in progress.
+func isRefreshing(c appengine.Context, importPath string) bool {
+	item, err := memcache.Get(c, refreshKeyPrefix+importPath)
+	return err == nil && len(item.Value) == 1 && item.Value[0] == 1
 }
 
 // handlerFunc adapts a function to an http.Handler. 
@@ -167,8 +328,11 @@ func (f handlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := f(w, r)
 	if err != nil {
 		appengine.NewContext(r).Errorf("Error %s", err.Error())
-		if e, ok := err.(doc.GetError); ok {
-			http.Error(w, "Error getting files from "+e.Host+".", http.StatusInternalServerError)
+		if serveFetchError(w, "", err) {
+			return
+		}
+		if err == doc.ErrBlocked {
+			executeTemplate(w, "blocked.html", http.StatusForbidden, map[string]interface{}{"importPath": ""})
 		} else if appengine.IsCapabilityDisabled(err) || appengine.IsOverQuota(err) {
 			http.Error(w, "Internal error: "+err.Error(), http.StatusInternalServerError)
 		} else {
@@ -177,6 +341,51 @@ func (f handlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveFetchError renders the page explaining an error getting files from a
+// version control service. The page has a form to fetch the package again if
+// importPath is not "". serveFetchError returns false if err is not an error
+// getting files.
+func serveFetchError(w http.ResponseWriter, importPath string, err error) bool {
+	if e, ok := err.(*doc.LimitError); ok {
+		executeTemplate(w, "fetcherror.html", http.StatusBadGateway, map[string]interface{}{
+			"kind":       "TooLarge",
+			"limit":      e.Limit,
+			"err":        e.Error(),
+			"importPath": importPath,
+		})
+		return true
+	}
+	e, ok := err.(doc.GetError)
+	if !ok {
+		return false
+	}
+	status := http.StatusBadGateway
+	switch e.Kind {
+	case doc.ErrorRateLimited:
+		status = http.StatusServiceUnavailable
+	case doc.ErrorTimeout:
+		status = http.StatusGatewayTimeout
+	case doc.ErrorRefused:
+		status = http.StatusForbidden
+	}
+	retryAfter := ""
+	if e.RetryAfter > 0 {
+		d := (e.RetryAfter/time.Second + 1) * time.Second
+		w.Header().Set("Retry-After", strconv.Itoa(int(d/time.Second)))
+		retryAfter = d.String()
+	}
+	executeTemplate(w, "fetcherror.html", status, map[string]interface{}{
+		"kind":       e.Kind.String(),
+		"host":       e.Host,
+		"location":   e.Location,
+		"retry":      e.Temporary(),
+		"retryAfter": retryAfter,
+		"err":        e.Error(),
+		"importPath": importPath,
+	})
+	return true
+}
+
 func servePackage(w http.ResponseWriter, r *http.Request) error {
 	c := appengine.NewContext(r)
 
@@ -187,21 +396,168 @@ func servePackage(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	importPath := r.URL.Path[1:]
-	pdoc, pkgs, err := getDoc(c, importPath)
+	v := currentViewer(c, r)
+	pdoc, pkgs, err := getDoc(c, v, importPath)
 	switch err {
 	case doc.ErrPackageNotFound:
-		return executeTemplate(w, "notfound.html", 404, nil)
+		return executeTemplate(w, "notfound.html", 404, map[string]interface{}{"importPath": importPath})
+	case doc.ErrBlocked:
+		return executeTemplate(w, "blocked.html", 403, map[string]interface{}{"importPath": importPath})
 	case nil:
 		//ok
 	default:
+		if serveFetchError(w, importPath, err) {
+			c.Errorf("Error %s", err.Error())
+			return nil
+		}
 		return err
 	}
 
+	if pdoc.CanonicalImportPath != "" {
+		// Redirect to the canonical import path if the documentation is
+		// available there. Otherwise, the page shows a warning.
+		cdoc, _, err := getDoc(c, v, pdoc.CanonicalImportPath)
+		switch err {
+		case nil:
+			if cdoc.CanonicalImportPath == "" && cdoc.Name != "" {
+				// The canonical path is set by the repository owner and
+				// can change, so the redirect is not permanent.
+				http.Redirect(w, r, "/"+pdoc.CanonicalImportPath, 302)
+				return nil
+			}
+		case doc.ErrPackageNotFound, doc.ErrBlocked:
+			// Show the warning.
+		default:
+			c.Errorf("getDoc(%s) -> %v", pdoc.CanonicalImportPath, err)
+		}
+	}
+
 	pkgs, cmds := filterCmds(pkgs)
 	return executeTemplate(w, "pkg.html", 200, map[string]interface{}{
-		"pkgs": pkgs,
-		"cmds": cmds,
-		"pdoc": pdoc,
+		"pkgs":       pkgs,
+		"cmds":       cmds,
+		"pdoc":       pdoc,
+		"refreshing": isRefreshing(c, importPath),
+	})
+}
+
+func serveSource(w http.ResponseWriter, r *http.Request) error {
+	c := appengine.NewContext(r)
+
+	p := path.Clean(r.URL.Path)
+	if p != r.URL.Path {
+		http.Redirect(w, r, p, 301)
+		return nil
+	}
+
+	importPath, name := path.Split(p[len("/-/src/"):])
+	if importPath == "" {
+		return executeTemplate(w, "notfound.html", 404, nil)
+	}
+	importPath = importPath[:len(importPath)-1]
+
+	pdoc, _, err := getDoc(c, currentViewer(c, r), importPath)
+	switch err {
+	case doc.ErrPackageNotFound:
+		return executeTemplate(w, "notfound.html", 404, map[string]interface{}{"importPath": importPath})
+	case doc.ErrBlocked:
+		return executeTemplate(w, "blocked.html", 403, map[string]interface{}{"importPath": importPath})
+	case nil:
+		//ok
+	default:
+		if serveFetchError(w, importPath, err) {
+			c.Errorf("Error %s", err.Error())
+			return nil
+		}
+		return err
+	}
+
+	for _, file := range pdoc.Files {
+		if file.Name == name {
+			return executeTemplate(w, "src.html", 200, map[string]interface{}{
+				"pdoc": pdoc,
+				"file": file,
+			})
+		}
+	}
+	return executeTemplate(w, "notfound.html", 404, nil)
+}
+
+// exampleRunnerURL is the URL of the example runner service started with
+// run/runserver.go. Examples are not runnable when the URL is "".
+var exampleRunnerURL = os.Getenv("EXAMPLE_RUNNER_URL")
+
+// exampleRunnerToken is the token sent to the example runner.
+var exampleRunnerToken = os.Getenv("EXAMPLE_RUNNER_TOKEN")
+
+// newExampleRunner returns the runner for examples.
+func newExampleRunner(c appengine.Context) run.Runner {
+	return &run.Client{URL: exampleRunnerURL, HTTPClient: urlfetch.Client(c), Token: exampleRunnerToken}
+}
+
+// findExample returns the example with the given name.
+func findExample(pdoc *doc.Package, name string) *doc.Example {
+	examples := pdoc.Examples
+	for _, f := range pdoc.Funcs {
+		examples = append(examples, f.Examples...)
+	}
+	for _, t := range pdoc.Types {
+		examples = append(examples, t.Examples...)
+		for _, f := range t.Funcs {
+			examples = append(examples, f.Examples...)
+		}
+		for _, f := range t.Methods {
+			examples = append(examples, f.Examples...)
+		}
+	}
+	for i := range examples {
+		if examples[i].Name == name {
+			return &examples[i]
+		}
+	}
+	return nil
+}
+
+func serveRunExample(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		http.Error(w, "Method not supported.", http.StatusMethodNotAllowed)
+		return nil
+	}
+	if exampleRunnerURL == "" {
+		http.Error(w, "Examples cannot be run on this server.", http.StatusNotFound)
+		return nil
+	}
+	c := appengine.NewContext(r)
+	pdoc, _, err := getDoc(c, currentViewer(c, r), r.FormValue("importPath"))
+	switch err {
+	case doc.ErrPackageNotFound:
+		http.Error(w, "Package not found.", http.StatusNotFound)
+		return nil
+	case nil:
+		//ok
+	default:
+		return err
+	}
+
+	example := findExample(pdoc, r.FormValue("name"))
+	if example == nil || example.Play == "" {
+		http.Error(w, "Example not found.", http.StatusNotFound)
+		return nil
+	}
+
+	result, err := newExampleRunner(c).Run(example.Play)
+	if err != nil {
+		return err
+	}
+	passed := result.Errors == ""
+	if passed && example.PlayOutput != "" {
+		passed = run.OutputMatches(result.Output, example.PlayOutput)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"Output": result.Output,
+		"Errors": result.Errors,
+		"Passed": passed,
 	})
 }
 
@@ -215,11 +571,88 @@ func serveClearPackageCache(w http.ResponseWriter, r *http.Request) error {
 	cacheKey := docKeyPrefix + importPath
 	err := memcache.Delete(c, cacheKey)
 	c.Infof("memcache.Delete(%s) -> %v", cacheKey, err)
+	clearFetchFailure(c, importPath)
 	removeDoc(c, importPath)
 	http.Redirect(w, r, "/"+importPath, 302)
 	return nil
 }
 
+// projectPackages returns the stored packages in the project, including the
+// package at the project root.
+func projectPackages(c appengine.Context, v *viewer, projectRoot string) ([]*Package, error) {
+	pkgs, err := queryPackages(c, projectListKeyPrefix+projectRoot,
+		datastore.NewQuery("Package").
+			Filter("__key__ >", datastore.NewKey(c, "Package", projectRoot+"/", 0, nil)).
+			Filter("__key__ <", datastore.NewKey(c, "Package", projectRoot+"0", 0, nil)))
+	if err != nil {
+		return nil, err
+	}
+	var root Package
+	err = datastore.Get(c, datastore.NewKey(c, "Package", projectRoot, 0, nil), &root)
+	switch err {
+	case nil:
+		root.ImportPath = projectRoot
+		pkgs = append([]*Package{&root}, pkgs...)
+	case datastore.ErrNoSuchEntity:
+		// OK
+	default:
+		return nil, err
+	}
+	return v.filter(pkgs), nil
+}
+
+func serveBrokenExamples(w http.ResponseWriter, r *http.Request) error {
+	c := appengine.NewContext(r)
+	projectRoot := r.URL.Path[len("/-/examples/"):]
+	pkgs, err := projectPackages(c, currentViewer(c, r), projectRoot)
+	if err != nil {
+		return err
+	}
+	broken := pkgs[0:0]
+	for _, pkg := range pkgs {
+		if len(pkg.BrokenExamples) > 0 {
+			broken = append(broken, pkg)
+		}
+	}
+	return executeTemplate(w, "examples.html", 200, map[string]interface{}{
+		"projectRoot": projectRoot,
+		"pkgs":        broken,
+	})
+}
+
+type byQualityScore []*Package
+
+func (p byQualityScore) Len() int           { return len(p) }
+func (p byQualityScore) Less(i, j int) bool { return p[i].QualityScore < p[j].QualityScore }
+func (p byQualityScore) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func serveQuality(w http.ResponseWriter, r *http.Request) error {
+	c := appengine.NewContext(r)
+	projectRoot := r.URL.Path[len("/-/quality/"):]
+	pkgs, err := projectPackages(c, currentViewer(c, r), projectRoot)
+	if err != nil {
+		return err
+	}
+	rated := pkgs[0:0]
+	total := 0
+	for _, pkg := range pkgs {
+		if pkg.QualityRated {
+			rated = append(rated, pkg)
+			total += pkg.QualityScore
+		}
+	}
+	sort.Stable(byQualityScore(rated))
+	average := 0
+	if len(rated) > 0 {
+		average = (total + len(rated)/2) / len(rated)
+	}
+	return executeTemplate(w, "quality.html", 200, map[string]interface{}{
+		"projectRoot": projectRoot,
+		"pkgs":        rated,
+		"average":     average,
+	})
+}
+
 func serveGoIndex(w http.ResponseWriter, r *http.Request) error {
 	c := appengine.NewContext(r)
 	pkgs, err := queryPackages(c, projectListKeyPrefix,
@@ -242,7 +675,7 @@ func serveIndex(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
-	pkgs, cmds := filterCmds(pkgs)
+	pkgs, cmds := filterCmds(currentViewer(c, r).filter(pkgs))
 	return executeTemplate(w, "index.html", 200, map[string]interface{}{
 		"pkgs": pkgs,
 		"cmds": cmds,
@@ -256,17 +689,22 @@ func rediretIndex(w http.ResponseWriter, r *http.Request) error {
 
 func serveAPIIndex(w http.ResponseWriter, r *http.Request) error {
 	c := appengine.NewContext(r)
-	keys, err := datastore.NewQuery("Package").KeysOnly().GetAll(c, nil)
+	var pkgs []*Package
+	keys, err := datastore.NewQuery("Package").GetAll(c, &pkgs)
 	if err != nil {
 		return err
 	}
+	v := currentViewer(c, r)
 	var buf bytes.Buffer
-	for _, key := range keys {
+	for i, key := range keys {
 		importPath := key.StringID()
 		if importPath[0] == '/' {
 			// fix standard package.
 			importPath = importPath[1:]
 		}
+		if !v.canView(importPath, pkgs[i].Private) {
+			continue
+		}
 		buf.WriteString(importPath)
 		buf.WriteByte('\n')
 	}
@@ -276,71 +714,6 @@ func serveAPIIndex(w http.ResponseWriter, r *http.Request) error {
 	return err
 }
 
-func serveAPIDump(w http.ResponseWriter, r *http.Request) error {
-	c := appengine.NewContext(r)
-	var pkgs []*Package
-	keys, err := datastore.NewQuery("Package").GetAll(c, &pkgs)
-	if err != nil {
-		return err
-	}
-	for i := range keys {
-		importPath := keys[i].StringID()
-		pkgs[i].ImportPath = importPath
-	}
-	return gob.NewEncoder(w).Encode(pkgs)
-}
-
-func serveAPILoad(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "POST" {
-		http.Error(w, "Method not supported.", http.StatusMethodNotAllowed)
-		return nil
-	}
-	c := appengine.NewContext(r)
-	var pkgs []*Package
-	err := gob.NewDecoder(r.Body).Decode(&pkgs)
-	if err != nil {
-		return err
-	}
-	for _, pkg := range pkgs {
-		key := datastore.NewKey(c, "Package", pkg.ImportPath, 0, nil)
-		if _, err := datastore.Put(c, key, pkg); err != nil {
-			c.Infof("%s %v", pkg.ImportPath, err)
-		}
-	}
-	err = memcache.Delete(c, packageListKey)
-	if err != nil {
-		c.Infof("clear %v", err)
-	}
-	return nil
-}
-
-func serveAPIHide(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != "POST" {
-		http.Error(w, "Method not supported.", http.StatusMethodNotAllowed)
-		return nil
-	}
-	c := appengine.NewContext(r)
-	importPath := r.FormValue("importPath")
-	key := datastore.NewKey(c, "Package", importPath, 0, nil)
-	var pkg Package
-	err := datastore.Get(c, key, &pkg)
-	if err == datastore.ErrNoSuchEntity {
-		io.WriteString(w, "no entity\n")
-		return nil
-	}
-	if err != nil {
-		return err
-	}
-	if pkg.Hide {
-		io.WriteString(w, "hide\n")
-		return nil
-	}
-	pkg.Hide = true
-	_, err = datastore.Put(c, key, &pkg)
-	io.WriteString(w, "ok\n")
-	return nil
-}
-
 func serveAPIUpdate(w http.ResponseWriter, r *http.Request) {
 	c := appengine.NewContext(r)
 	if r.Method != "POST" {
@@ -348,12 +721,14 @@ func serveAPIUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	importPath := r.FormValue("importPath")
-	pdoc, err := doc.Get(urlfetch.Client(c), importPath, "")
+	pdoc, err := getFromService(c, importPath, "")
 	if err == nil || err == doc.ErrPackageNotFound {
 		err = updatePackage(c, importPath, pdoc)
 	}
 
-	if err != nil {
+	if err == doc.ErrBlocked {
+		io.WriteString(w, "BLOCKED\n")
+	} else if err != nil {
 		c.Errorf("Error %s", err.Error())
 		io.WriteString(w, "INTERNAL ERROR\n")
 	} else if pdoc == nil {
@@ -455,6 +830,7 @@ func serveHome(w http.ResponseWriter, r *http.Request) error {
 		return executeTemplate(w, "home.html", 200, nil)
 	}
 
+	v := currentViewer(c, r)
 	q = cleanQuery(q)
 	if len(q) == 0 {
 		return executeTemplate(w, "results.html", 200, map[string]interface{}{"q": q, "pkgs": nil})
@@ -464,7 +840,7 @@ func serveHome(w http.ResponseWriter, r *http.Request) error {
 	// documentation by import path. This will fetch the documentation from the
 	// VCS if we have not seen this import path before.
 	if doc.ValidRemotePath(q) {
-		_, _, err := getDoc(c, q)
+		_, _, err := getDoc(c, v, q)
 		switch err {
 		case nil:
 			// Automatic I'm feeling lucky.
@@ -472,6 +848,8 @@ func serveHome(w http.ResponseWriter, r *http.Request) error {
 			return nil
 		case doc.ErrPackageNotFound:
 			// Continue on to search.
+		case doc.ErrBlocked:
+			return executeTemplate(w, "blocked.html", 403, map[string]interface{}{"importPath": q})
 		default:
 			return err
 		}
@@ -493,6 +871,7 @@ func serveHome(w http.ResponseWriter, r *http.Request) error {
 		}
 		pkgs[i].ImportPath = importPath
 	}
+	pkgs = v.filter(pkgs)
 
 	return executeTemplate(w, "results.html", 200, map[string]interface{}{"q": q, "pkgs": pkgs})
 }
@@ -501,16 +880,104 @@ func serveAbout(w http.ResponseWriter, r *http.Request) error {
 	return executeTemplate(w, "about.html", 200, map[string]interface{}{"Host": r.Host})
 }
 
+// serverConfig returns the servers in the environment variable name. The
+// variable is a space separated list of servers. Each server is n comma
+// separated fields followed by an optional token. The token is "" when not
+// set.
+func serverConfig(name string, n int) [][]string {
+	var servers [][]string
+	for _, server := range strings.Fields(os.Getenv(name)) {
+		f := strings.Split(server, ",")
+		switch len(f) {
+		case n:
+			f = append(f, "")
+		case n + 1:
+		default:
+			panic("bad " + name + " entry " + server)
+		}
+		servers = append(servers, f)
+	}
+	return servers
+}
+
+// envInt64 returns the value of the environment variable as an integer or
+// zero if the variable is empty or not an integer.
+func envInt64(name string) int64 {
+	n, _ := strconv.ParseInt(os.Getenv(name), 10, 64)
+	return n
+}
+
 func init() {
 	http.Handle("/", handlerFunc(serveHome))
 	http.Handle("/index", handlerFunc(rediretIndex)) // Delete this in late 2012.
 	http.Handle("/-/about", handlerFunc(serveAbout))
 	http.Handle("/-/index", handlerFunc(serveIndex))
 	http.Handle("/-/go", handlerFunc(serveGoIndex))
+	http.Handle("/-/examples/", handlerFunc(serveBrokenExamples))
+	http.Handle("/-/quality/", handlerFunc(serveQuality))
+	http.Handle("/-/src/", handlerFunc(serveSource))
 	http.Handle("/-/refresh", handlerFunc(serveClearPackageCache))
+	http.Handle("/-/run", handlerFunc(serveRunExample))
+	http.Handle("/-/admin", adminHandlerFunc(serveAdmin))
 	http.Handle("/a/index", handlerFunc(serveAPIIndex))
 	http.Handle("/a/update", http.HandlerFunc(serveAPIUpdate))
-	//http.Handle("/a/dump", handlerFunc(serveAPIDump))
-	//http.Handle("/a/load", handlerFunc(serveAPILoad))
-	//http.Handle("/a/hide", handlerFunc(serveAPIHide))
+	http.Handle("/a/dump", adminHandlerFunc(serveAPIDump))
+	http.Handle("/a/load", adminActionFunc(serveAPILoad))
+	http.Handle("/a/hide", adminActionFunc(serveAPIHide))
+	http.Handle("/a/unhide", adminActionFunc(serveAPIHide))
+	http.Handle("/a/block", adminActionFunc(serveAPIBlock))
+	http.Handle("/a/unblock", adminActionFunc(serveAPIBlock))
+	http.Handle("/a/purge", adminActionFunc(serveAPIPurge))
+
+	for _, f := range serverConfig("GITHUB_SERVERS", 3) {
+		doc.AddGithubServer(f[0], f[1], f[2], f[3])
+	}
+	for _, f := range serverConfig("GITLAB_SERVERS", 2) {
+		doc.AddGitlabServer(f[0], f[1], f[2])
+	}
+	for _, f := range serverConfig("GITEA_SERVERS", 2) {
+		doc.AddGiteaServer(f[0], f[1], f[2])
+	}
+
+	maxRedirects, _ := strconv.Atoi(os.Getenv("FETCH_MAX_REDIRECTS"))
+	policy, err := doc.NewFetchPolicy(
+		strings.Fields(os.Getenv("FETCH_ALLOW_HOSTS")),
+		strings.Fields(os.Getenv("FETCH_ALLOW_NETS")),
+		maxRedirects)
+	if err != nil {
+		panic(err)
+	}
+	doc.SetFetchPolicy(policy)
+
+	maxFiles, _ := strconv.Atoi(os.Getenv("FETCH_MAX_FILES"))
+	doc.SetLimits(doc.Limits{
+		MaxArchiveBytes: envInt64("FETCH_MAX_ARCHIVE_BYTES"),
+		MaxFileBytes:    envInt64("FETCH_MAX_FILE_BYTES"),
+		MaxFiles:        maxFiles,
+		MaxRatio:        envInt64("FETCH_MAX_RATIO"),
+	})
+
+	if name := os.Getenv("CREDENTIALS"); name != "" {
+		p, err := ioutil.ReadFile(name)
+		if err != nil {
+			panic(err)
+		}
+		var credentials doc.CredentialsList
+		if err := json.Unmarshal(p, &credentials); err != nil {
+			panic(err)
+		}
+		doc.SetCredentialsProvider(credentials)
+	}
+
+	if name := os.Getenv("ACCESS_RULES"); name != "" {
+		if err := loadAccessRules(name); err != nil {
+			panic(err)
+		}
+	}
+
+	if name := os.Getenv("VANITY_RULES"); name != "" {
+		if err := doc.LoadVanityRules(name); err != nil {
+			panic(err)
+		}
+	}
 }
@@ -0,0 +1,316 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build appengine
+
+package app
+
+import (
+	"appengine"
+	"appengine/memcache"
+	"doc"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	fetchLeaseKeyPrefix  = "fetch:"
+	fetchResultKeyPrefix = "fetchresult:"
+
+	// Expiration of the lease held by the instance fetching a package. The
+	// lease expires if the instance dies while fetching.
+	fetchLeaseExpiration = 2 * time.Minute
+
+	// Time to wait for another instance to fetch a package.
+	fetchWait         = 30 * time.Second
+	fetchPollInterval = 500 * time.Millisecond
+)
+
+type fetchCall struct {
+	done chan struct{}
+	pdoc *doc.Package
+	err  error
+}
+
+// fetchCalls is the set of fetches in progress in this instance.
+var fetchCalls = struct {
+	sync.Mutex
+	m map[string]*fetchCall
+}{m: make(map[string]*fetchCall)}
+
+// singleFetch calls fetch unless a fetch of importPath is in progress. If a
+// fetch is in progress in this instance, singleFetch waits for the result of
+// the fetch. If a fetch is in progress in another instance, singleFetch
+// returns pdocSaved or, when there is no saved documentation, waits for the
+// result of the fetch in the other instance.
+func singleFetch(c appengine.Context, importPath string, pdocSaved *doc.Package, fetch func() (*doc.Package, error)) (*doc.Package, error) {
+	fetchCalls.Lock()
+	if call := fetchCalls.m[importPath]; call != nil {
+		fetchCalls.Unlock()
+		<-call.done
+		return call.pdoc, call.err
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	fetchCalls.m[importPath] = call
+	fetchCalls.Unlock()
+
+	call.pdoc, call.err = leasedFetch(c, importPath, pdocSaved, fetch)
+
+	fetchCalls.Lock()
+	delete(fetchCalls.m, importPath)
+	fetchCalls.Unlock()
+	close(call.done)
+	return call.pdoc, call.err
+}
+
+// fetchResult is the outcome of a fetch published by the instance holding
+// the lease to the instances waiting for the fetch.
+type fetchResult struct {
+	// Value of the lease held for the fetch.
+	Lease string
+
+	// True if the documentation is in the datastore.
+	Stored bool
+
+	// Error returned by the fetch.
+	Error string
+}
+
+// leasedFetch calls fetch while holding the lease for importPath in
+// memcache. The lease value is a token that identifies the fetch.
+func leasedFetch(c appengine.Context, importPath string, pdocSaved *doc.Package, fetch func() (*doc.Package, error)) (*doc.Package, error) {
+	key := fetchLeaseKeyPrefix + importPath
+	deadline := time.Now().Add(fetchWait)
+	holder := ""
+	for {
+		token, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		err = memcache.Add(c, &memcache.Item{Key: key, Value: []byte(token), Expiration: fetchLeaseExpiration})
+		switch err {
+		case nil:
+			defer releaseFetchLease(c, key, token)
+			pdoc, err := fetch()
+			publishFetchResult(c, importPath, token, pdoc, err)
+			return pdoc, err
+		case memcache.ErrNotStored:
+			// Another instance is fetching the package.
+			if pdocSaved != nil {
+				return pdocSaved, nil
+			}
+			if holder == "" {
+				if item, err := memcache.Get(c, key); err == nil {
+					holder = string(item.Value)
+				}
+			}
+		default:
+			c.Errorf("memcache.Add(%s) -> %v", key, err)
+			return fetch()
+		}
+		if time.Now().After(deadline) {
+			c.Warningf("Timeout waiting for fetch of %s in another instance.", importPath)
+			return fetch()
+		}
+		time.Sleep(fetchPollInterval)
+		if pdoc, done, err := fetchOutcome(c, importPath, holder); done {
+			return pdoc, err
+		}
+	}
+}
+
+// releaseFetchLease deletes the lease if the lease still has the value token.
+// The lease is swapped before it is deleted so that a lease that expired and
+// was taken by another instance is not deleted. The swapped lease does not
+// expire before it is deleted.
+func releaseFetchLease(c appengine.Context, key, token string) {
+	item, err := memcache.Get(c, key)
+	if err != nil || string(item.Value) != token {
+		return
+	}
+	item.Value = []byte("released")
+	item.Expiration = fetchLeaseExpiration
+	if err := memcache.CompareAndSwap(c, item); err != nil {
+		return
+	}
+	if err := memcache.Delete(c, key); err != nil && err != memcache.ErrCacheMiss {
+		c.Errorf("memcache.Delete(%s) -> %v", key, err)
+	}
+}
+
+// publishFetchResult makes the outcome of the fetch with the lease token
+// available to the instances waiting for the fetch.
+func publishFetchResult(c appengine.Context, importPath, token string, pdoc *doc.Package, err error) {
+	r := fetchResult{Lease: token, Stored: err == nil && pdoc != nil}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	item := &memcache.Item{Key: fetchResultKeyPrefix + importPath, Object: &r, Expiration: fetchWait}
+	if err := cacheSet(c, item); err != nil {
+		c.Errorf("cacheSet(%s) -> %v", item.Key, err)
+	}
+}
+
+// fetchOutcome returns the result of a fetch of importPath by another
+// instance. The result is ignored if holder is set and the lease of the
+// fetch is not holder. The boolean result is false if the fetch is not done.
+func fetchOutcome(c appengine.Context, importPath, holder string) (*doc.Package, bool, error) {
+	var pdoc *doc.Package
+	if _, err := cacheGet(c, docKeyPrefix+importPath, &pdoc); err == nil {
+		return pdoc, true, nil
+	}
+	var r fetchResult
+	if _, err := cacheGet(c, fetchResultKeyPrefix+importPath, &r); err != nil {
+		return nil, false, nil
+	}
+	if holder != "" && r.Lease != holder {
+		return nil, false, nil
+	}
+	if r.Stored {
+		// The documentation is too large for memcache.
+		pdoc, _, err := loadDoc(c, importPath)
+		if err == nil && pdoc == nil {
+			err = doc.ErrPackageNotFound
+		}
+		return pdoc, true, err
+	}
+	for _, err := range []error{doc.ErrPackageNotFound, doc.ErrBlocked} {
+		if r.Error == err.Error() {
+			return nil, true, err
+		}
+	}
+	// Fetch errors are remembered as fetch failures.
+	if err := checkFetchFailure(c, importPath); err != nil {
+		return nil, true, err
+	}
+	return nil, true, errors.New(r.Error)
+}
+
+const (
+	fetchFailureKeyPrefix = "failure:"
+
+	// The time that a failure is remembered doubles with each consecutive
+	// failure up to the maximum.
+	notFoundTTL    = 10 * time.Minute
+	maxNotFoundTTL = 24 * time.Hour
+	errorTTL       = time.Minute
+	maxErrorTTL    = time.Hour
+)
+
+// fetchFailure records a failed fetch of a package. Fetches of the package
+// are not attempted until the failure expires.
+type fetchFailure struct {
+	NotFound   bool
+	Host       string
+	Kind       doc.ErrorKind
+	Status     int
+	Count      int
+	Expiration time.Time
+
+	// The limit exceeded by the package or nil.
+	Limit *doc.LimitError
+}
+
+// error returns the error for the failure.
+func (f *fetchFailure) error() error {
+	if f.NotFound {
+		return doc.ErrPackageNotFound
+	}
+	if f.Limit != nil {
+		return f.Limit
+	}
+	return doc.GetError{
+		Host:       f.Host,
+		Kind:       f.Kind,
+		Status:     f.Status,
+		RetryAfter: f.Expiration.Sub(time.Now()),
+	}
+}
+
+// checkFetchFailure returns the error from a recent failed fetch of
+// importPath or nil if fetching the package should be attempted.
+func checkFetchFailure(c appengine.Context, importPath string) error {
+	var f fetchFailure
+	if _, err := cacheGet(c, fetchFailureKeyPrefix+importPath, &f); err != nil {
+		if err != memcache.ErrCacheMiss {
+			c.Errorf("cacheGet(%s) -> %v", fetchFailureKeyPrefix+importPath, err)
+		}
+		return nil
+	}
+	if time.Now().After(f.Expiration) {
+		return nil
+	}
+	return f.error()
+}
+
+// recordFetchFailure remembers the result of a fetch of importPath. The
+// failure is cleared if err is nil. Errors other than doc.ErrPackageNotFound,
+// doc.GetError and *doc.LimitError are not remembered. Packages that exceed a
+// limit are remembered like packages that are not found so that the
+// repository is not downloaded again on every view.
+func recordFetchFailure(c appengine.Context, importPath string, err error) {
+	key := fetchFailureKeyPrefix + importPath
+	if err == nil {
+		if err := memcache.Delete(c, key); err != nil && err != memcache.ErrCacheMiss {
+			c.Errorf("memcache.Delete(%s) -> %v", key, err)
+		}
+		return
+	}
+
+	e, isGetError := err.(doc.GetError)
+	limitErr, isLimitError := err.(*doc.LimitError)
+	if err != doc.ErrPackageNotFound && !isGetError && !isLimitError {
+		return
+	}
+
+	var f fetchFailure
+	if _, err := cacheGet(c, key, &f); err != nil && err != memcache.ErrCacheMiss {
+		c.Errorf("cacheGet(%s) -> %v", key, err)
+	}
+	ttl, maxTTL := errorTTL, maxErrorTTL
+	f.NotFound, f.Limit = false, nil
+	switch {
+	case isGetError:
+		f.Host, f.Kind, f.Status = e.Host, e.Kind, e.Status
+		if e.RetryAfter > ttl {
+			ttl = e.RetryAfter
+		}
+	case isLimitError:
+		f.Limit = limitErr
+		ttl, maxTTL = notFoundTTL, maxNotFoundTTL
+	default:
+		f.NotFound = true
+		ttl, maxTTL = notFoundTTL, maxNotFoundTTL
+	}
+	for i := 0; i < f.Count && ttl < maxTTL; i++ {
+		ttl *= 2
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	f.Count++
+	f.Expiration = time.Now().Add(ttl)
+
+	// Keep the failure after it expires to back off on the next failure.
+	item := &memcache.Item{Key: key, Object: &f, Expiration: 2 * ttl}
+	if err := cacheSet(c, item); err != nil {
+		c.Errorf("cacheSet(%s) -> %v", key, err)
+	}
+}
+
+// clearFetchFailure forgets the failed fetches of importPath.
+func clearFetchFailure(c appengine.Context, importPath string) {
+	recordFetchFailure(c, importPath, nil)
+}
@@ -21,8 +21,10 @@ import (
 	"appengine/datastore"
 	"appengine/memcache"
 	"bytes"
+	"compress/gzip"
 	"doc"
 	"encoding/gob"
+	"io/ioutil"
 	"path"
 	"strings"
 	"time"
@@ -40,35 +42,203 @@ type Package struct {
 	IsCmd       bool   `datastore:",noindex"`
 	Hide        bool
 	IndexTokens []string
+
+	// Names and errors of examples that failed verification.
+	BrokenExamples []string `datastore:",noindex"`
+
+	// Documentation quality score. QualityRated is false for packages
+	// without a score.
+	QualityScore int  `datastore:",noindex"`
+	QualityRated bool `datastore:",noindex"`
+
+	// True if the package was fetched with private credentials. Private
+	// packages are hidden and shown to administrators only.
+	Private bool `datastore:",noindex"`
+
+	// True if an administrator hid the package. The flag is preserved when
+	// the package is updated.
+	HiddenByAdmin bool `datastore:",noindex"`
 }
 
+// Doc is the stored documentation for a package. The documentation is
+// encoded with gob, compressed with gzip and split into chunks to fit the
+// datastore entity size limit. The first chunk is stored in the Doc entity.
+// The remaining chunks are stored in DocChunk entities with the Doc entity as
+// parent.
 type Doc struct {
 	Version string `datastore:",noindex"`
+	Chunks  int    `datastore:",noindex"`
 	Gob     []byte `datastore:",noindex"`
 }
 
+type DocChunk struct {
+	Gob []byte `datastore:",noindex"`
+}
+
+// docChunkSize is the maximum number of bytes stored in a single entity.
+const docChunkSize = 800000
+
+func docChunkKeys(c appengine.Context, key *datastore.Key, n int) []*datastore.Key {
+	keys := make([]*datastore.Key, n)
+	for i := range keys {
+		keys[i] = datastore.NewKey(c, "DocChunk", "", int64(i+1), key)
+	}
+	return keys
+}
+
+// encodeDoc encodes the documentation for storage.
+func encodeDoc(pdoc *doc.Package) ([]byte, error) {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(gzw).Encode(pdoc); err != nil {
+		return nil, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeDoc decodes documentation encoded with encodeDoc. The whole stream is
+// read so that the gzip checksum detects chunks from different versions of
+// the documentation.
+func decodeDoc(p []byte) (*doc.Package, error) {
+	gzr, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	p, err = ioutil.ReadAll(gzr)
+	if err != nil {
+		return nil, err
+	}
+	var pdoc doc.Package
+	if err := gob.NewDecoder(bytes.NewReader(p)).Decode(&pdoc); err != nil {
+		return nil, err
+	}
+	return &pdoc, nil
+}
+
+// splitDoc splits p into chunks of at most size bytes. There is at least one
+// chunk.
+func splitDoc(p []byte, size int) [][]byte {
+	chunks := [][]byte{}
+	for len(p) > size {
+		chunks = append(chunks, p[:size])
+		p = p[size:]
+	}
+	return append(chunks, p)
+}
+
+// joinDoc joins the chunks returned by splitDoc.
+func joinDoc(chunks [][]byte) []byte {
+	return bytes.Join(chunks, nil)
+}
+
+// loadDoc returns the stored documentation for a package. If the
+// documentation is not stored or cannot be decoded, nil is returned so that
+// the caller fetches the package again.
 func loadDoc(c appengine.Context, importPath string) (*doc.Package, string, error) {
+	key := datastore.NewKey(c, "Doc", importPath, 0, nil)
 	var d Doc
-	err := datastore.Get(c, datastore.NewKey(c, "Doc", importPath, 0, nil), &d)
+	err := datastore.Get(c, key, &d)
 	if err == datastore.ErrNoSuchEntity {
 		return nil, "", nil
 	}
 	if err != nil {
 		return nil, "", err
 	}
-	if d.Version != doc.PackageVersion {
+	if d.Version != doc.PackageVersion || d.Chunks < 1 {
 		return nil, "", nil
 	}
-	var p doc.Package
-	err = gob.NewDecoder(bytes.NewBuffer(d.Gob)).Decode(&p)
-	return &p, p.Etag, err
+
+	chunks := [][]byte{d.Gob}
+	if d.Chunks > 1 {
+		dchunks := make([]DocChunk, d.Chunks-1)
+		if err := datastore.GetMulti(c, docChunkKeys(c, key, len(dchunks)), dchunks); err != nil {
+			if _, ok := err.(appengine.MultiError); ok {
+				// A chunk was removed by a concurrent update.
+				c.Errorf("GetMulti chunks(%s) -> %v", importPath, err)
+				return nil, "", nil
+			}
+			return nil, "", err
+		}
+		for _, chunk := range dchunks {
+			chunks = append(chunks, chunk.Gob)
+		}
+	}
+
+	pdoc, err := decodeDoc(joinDoc(chunks))
+	if err != nil {
+		// A concurrent update can replace the chunks between reading the
+		// parent and reading the chunks. The mixed data fails to decode
+		// and is treated as a miss.
+		c.Errorf("decodeDoc(%s) -> %v", importPath, err)
+		return nil, "", nil
+	}
+	return pdoc, pdoc.Etag, nil
+}
+
+// putDoc stores the documentation for a package, replacing any previously
+// stored documentation. The chunks are written before the parent. A reader
+// can still see the old parent with new chunks during an update, see
+// loadDoc.
+func putDoc(c appengine.Context, importPath string, pdoc *doc.Package) error {
+	p, err := encodeDoc(pdoc)
+	if err != nil {
+		return err
+	}
+	parts := splitDoc(p, docChunkSize)
+	chunks := make([]DocChunk, len(parts)-1)
+	for i := range chunks {
+		chunks[i].Gob = parts[i+1]
+	}
+
+	key := datastore.NewKey(c, "Doc", importPath, 0, nil)
+	if len(chunks) > 0 {
+		if _, err := datastore.PutMulti(c, docChunkKeys(c, key, len(chunks)), chunks); err != nil {
+			return err
+		}
+	}
+	d := Doc{
+		Version: doc.PackageVersion,
+		Chunks:  len(parts),
+		Gob:     parts[0],
+	}
+	if _, err := datastore.Put(c, key, &d); err != nil {
+		return err
+	}
+	removeDocChunks(c, key, len(chunks))
+	return nil
+}
+
+// removeDocChunks deletes the chunks following the first n chunks of a doc.
+func removeDocChunks(c appengine.Context, key *datastore.Key, n int) {
+	keys, err := datastore.NewQuery("DocChunk").Ancestor(key).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		c.Errorf("Query chunks(%s) -> %v", key.StringID(), err)
+		return
+	}
+	stale := keys[0:0]
+	for _, k := range keys {
+		if k.IntID() > int64(n) {
+			stale = append(stale, k)
+		}
+	}
+	if len(stale) > 0 {
+		if err := datastore.DeleteMulti(c, stale); err != nil {
+			c.Errorf("Delete chunks(%s) -> %v", key.StringID(), err)
+		}
+	}
 }
 
 func removeDoc(c appengine.Context, importPath string) {
-	err := datastore.Delete(c, datastore.NewKey(c, "Doc", importPath, 0, nil))
+	key := datastore.NewKey(c, "Doc", importPath, 0, nil)
+	err := datastore.Delete(c, key)
 	if err != nil && err != datastore.ErrNoSuchEntity {
 		c.Errorf("Delete(%s) -> %v", importPath, err)
 	}
+	removeDocChunks(c, key, 0)
 }
 
 func queryPackages(c appengine.Context, cacheKey string, query *datastore.Query) ([]*Package, error) {
@@ -108,6 +278,12 @@ func (pkg *Package) equal(other *Package) bool {
 	if pkg.IsCmd != other.IsCmd {
 		return false
 	}
+	if pkg.Private != other.Private || pkg.HiddenByAdmin != other.HiddenByAdmin {
+		return false
+	}
+	if pkg.QualityScore != other.QualityScore || pkg.QualityRated != other.QualityRated {
+		return false
+	}
 	if len(pkg.IndexTokens) != len(other.IndexTokens) {
 		return false
 	}
@@ -116,9 +292,95 @@ func (pkg *Package) equal(other *Package) bool {
 			return false
 		}
 	}
+	if len(pkg.BrokenExamples) != len(other.BrokenExamples) {
+		return false
+	}
+	for i := range pkg.BrokenExamples {
+		if pkg.BrokenExamples[i] != other.BrokenExamples[i] {
+			return false
+		}
+	}
 	return true
 }
 
+// brokenExamples returns the names and first errors of the examples in pdoc
+// that failed verification.
+func brokenExamples(pdoc *doc.Package) []string {
+	var result []string
+	add := func(examples []doc.Example) {
+		for _, e := range examples {
+			if len(e.Errors) > 0 {
+				name := e.Name
+				if name == "" {
+					name = "package"
+				}
+				result = append(result, name+": "+e.Errors[0])
+			}
+		}
+	}
+	add(pdoc.Examples)
+	for _, f := range pdoc.Funcs {
+		add(f.Examples)
+	}
+	for _, t := range pdoc.Types {
+		add(t.Examples)
+		for _, f := range t.Funcs {
+			add(f.Examples)
+		}
+		for _, f := range t.Methods {
+			add(f.Examples)
+		}
+	}
+	return result
+}
+
+// packageIndex returns whether the package is hidden from the index and the
+// tokens used to find the package in search. Private packages are indexed like
+// other packages. The index and search results are filtered for the viewer.
+func packageIndex(importPath string, pdoc *doc.Package) (bool, []string) {
+	indexTokens := make([]string, 0, 3)
+	if pdoc.ProjectRoot != "" {
+		indexTokens = append(indexTokens, strings.ToLower(pdoc.ProjectRoot))
+	}
+
+	hide := false
+	switch {
+	case strings.HasPrefix(importPath, "code.google.com/p/go/"):
+		hide = true
+	case pdoc.ProjectRoot == "":
+		// standard packages
+		hide = true
+		indexTokens = append(indexTokens, strings.ToLower(pdoc.Name))
+	case pdoc.IsCmd:
+		// Hide if command does not have a synopsis or doc with more than one sentence.
+		i := strings.Index(pdoc.Doc, ".")
+		hide = pdoc.Synopsis == "" || i < 0 || i == len(pdoc.Doc)-1
+		if !hide {
+			_, name := path.Split(strings.ToLower(pdoc.ImportPath))
+			indexTokens = append(indexTokens, name)
+		}
+	default:
+		// Hide if no exports.
+		hide = len(pdoc.Consts) == 0 && len(pdoc.Funcs) == 0 && len(pdoc.Types) == 0 && len(pdoc.Vars) == 0
+		if !hide {
+			_, name := path.Split(strings.ToLower(pdoc.ImportPath))
+			indexTokens = append(indexTokens, name)
+			name = strings.ToLower(pdoc.Name)
+			if name != indexTokens[len(indexTokens)-1] {
+				indexTokens = append(indexTokens, name)
+			}
+		}
+	}
+
+	if pdoc.CanonicalImportPath != "" {
+		// Hide forks and mirrors from the index and search.
+		hide = true
+		indexTokens = nil
+	}
+
+	return hide, indexTokens
+}
+
 // updatePackage updates the package in the datastore and clears memcache as
 // needed.
 func updatePackage(c appengine.Context, importPath string, pdoc *doc.Package) error {
@@ -126,83 +388,29 @@ func updatePackage(c appengine.Context, importPath string, pdoc *doc.Package) er
 	var pkg *Package
 	if pdoc != nil && pdoc.Name != "" {
 
-		indexTokens := make([]string, 0, 3)
-		if pdoc.ProjectRoot != "" {
-			indexTokens = append(indexTokens, strings.ToLower(pdoc.ProjectRoot))
-		}
-
-		hide := false
-		switch {
-		case strings.HasPrefix(importPath, "code.google.com/p/go/"):
-			hide = true
-		case pdoc.ProjectRoot == "":
-			// standard packages
-			hide = true
-			indexTokens = append(indexTokens, strings.ToLower(pdoc.Name))
-		case pdoc.IsCmd:
-			// Hide if command does not have a synopsis or doc with more than one sentence.
-			i := strings.Index(pdoc.Doc, ".")
-			hide = pdoc.Synopsis == "" || i < 0 || i == len(pdoc.Doc)-1
-			if !hide {
-				_, name := path.Split(strings.ToLower(pdoc.ImportPath))
-				indexTokens = append(indexTokens, name)
-			}
-		default:
-			// Hide if no exports.
-			hide = len(pdoc.Consts) == 0 && len(pdoc.Funcs) == 0 && len(pdoc.Types) == 0 && len(pdoc.Vars) == 0
-			if !hide {
-				_, name := path.Split(strings.ToLower(pdoc.ImportPath))
-				indexTokens = append(indexTokens, name)
-				name = strings.ToLower(pdoc.Name)
-				if name != indexTokens[len(indexTokens)-1] {
-					indexTokens = append(indexTokens, name)
-				}
-			}
-		}
+		hide, indexTokens := packageIndex(importPath, pdoc)
 
 		pkg = &Package{
-			Synopsis:    pdoc.Synopsis,
-			PackageName: pdoc.Name,
-			IsCmd:       pdoc.IsCmd,
-			Hide:        hide,
-			IndexTokens: indexTokens,
+			Synopsis:       pdoc.Synopsis,
+			PackageName:    pdoc.Name,
+			IsCmd:          pdoc.IsCmd,
+			Hide:           hide,
+			IndexTokens:    indexTokens,
+			BrokenExamples: brokenExamples(pdoc),
+			Private:        pdoc.Private,
+		}
+		if pdoc.Quality != nil {
+			pkg.QualityScore = pdoc.Quality.Score
+			pkg.QualityRated = true
 		}
 	}
 
 	// Update doc blob.
 
-	key := datastore.NewKey(c, "Doc", importPath, 0, nil)
 	if pkg == nil {
-		if err := datastore.Delete(c, key); err != datastore.ErrNoSuchEntity && err != nil {
-			c.Errorf("Delete(%s) -> %v", importPath, err)
-		}
-	} else {
-		var buf bytes.Buffer
-		err := gob.NewEncoder(&buf).Encode(pdoc)
-		if err != nil {
-			return err
-		}
-
-		if buf.Len() > 800000 {
-			pdoc.Errors = append(pdoc.Errors, "Documentation truncated.")
-			pdoc.Vars = nil
-			pdoc.Funcs = nil
-			pdoc.Types = nil
-			pdoc.Consts = nil
-			buf.Reset()
-			err := gob.NewEncoder(&buf).Encode(pdoc)
-			if err != nil {
-				return err
-			}
-		}
-
-		doc := Doc{
-			Version: doc.PackageVersion,
-			Gob:     buf.Bytes(),
-		}
-		if _, err := datastore.Put(c, key, &doc); err != nil {
-			c.Errorf("Put(%s) -> %v", importPath, err)
-		}
+		removeDoc(c, importPath)
+	} else if err := putDoc(c, importPath, pdoc); err != nil {
+		c.Errorf("putDoc(%s) -> %v", importPath, err)
 	}
 
 	// Update the package index. To minimize datastore costs and cache
@@ -216,9 +424,14 @@ func updatePackage(c appengine.Context, importPath string, pdoc *doc.Package) er
 	}
 
 	var invalidateCache bool
-	key = datastore.NewKey(c, "Package", keyName, 0, nil)
+	key := datastore.NewKey(c, "Package", keyName, 0, nil)
 	var storedPackage Package
 	err := datastore.Get(c, key, &storedPackage)
+	if err == nil && pkg != nil && storedPackage.HiddenByAdmin {
+		pkg.HiddenByAdmin = true
+		pkg.Hide = true
+		pkg.IndexTokens = nil
+	}
 	switch err {
 	case datastore.ErrNoSuchEntity:
 		if pkg != nil {
@@ -0,0 +1,118 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build appengine
+
+package app
+
+import (
+	"doc"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPrivatePackageSearch(t *testing.T) {
+	saved := accessRules
+	defer func() { accessRules = saved }()
+	accessRules = []*accessRule{{Prefix: "git.example.com/team/", Users: []string{"a@example.com"}}}
+
+	importPath := "git.example.com/team/p"
+	pdoc := &doc.Package{
+		ImportPath:  importPath,
+		ProjectRoot: importPath,
+		Name:        "p",
+		Synopsis:    "Package p is a test.",
+		Funcs:       []*doc.Func{{Name: "F"}},
+		Private:     true,
+	}
+	hide, indexTokens := packageIndex(importPath, pdoc)
+	if hide {
+		t.Error("private package is hidden from the index")
+	}
+	found := false
+	for _, token := range indexTokens {
+		if token == "p" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("indexTokens = %q, want token %q", indexTokens, "p")
+	}
+
+	// The search query returns the package for all viewers. The results
+	// are filtered for the viewer.
+	for _, tt := range []struct {
+		v    *viewer
+		want int
+	}{
+		{&viewer{email: "a@example.com"}, 1},
+		{&viewer{email: "b@example.com"}, 0},
+		{&viewer{}, 0},
+		{&viewer{admin: true}, 1},
+	} {
+		pkgs := []*Package{{ImportPath: importPath, Private: true, Hide: hide, IndexTokens: indexTokens}}
+		if n := len(tt.v.filter(pkgs)); n != tt.want {
+			t.Errorf("viewer %+v found %d packages, want %d", tt.v, n, tt.want)
+		}
+	}
+}
+
+func TestDocChunks(t *testing.T) {
+	docs := make([]*doc.Package, 2)
+	encoded := make([][]byte, 2)
+	for i := range docs {
+		docs[i] = &doc.Package{ImportPath: "example.com/p", Name: "p", Etag: "ab"[i : i+1]}
+		for j := 0; j < 200; j++ {
+			docs[i].Funcs = append(docs[i].Funcs, &doc.Func{Name: fmt.Sprintf("F%d_%d", i, j), Doc: strings.Repeat("x", j)})
+		}
+		p, err := encodeDoc(docs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded[i] = p
+	}
+
+	for _, size := range []int{1, 100, len(encoded[0]) - 1, len(encoded[0]), len(encoded[0]) + 1} {
+		chunks := splitDoc(encoded[0], size)
+		if n := (len(encoded[0]) + size - 1) / size; len(chunks) != n {
+			t.Errorf("size %d: got %d chunks, want %d", size, len(chunks), n)
+		}
+		for _, chunk := range chunks {
+			if len(chunk) > size {
+				t.Errorf("size %d: chunk has %d bytes", size, len(chunk))
+			}
+		}
+		pdoc, err := decodeDoc(joinDoc(chunks))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if pdoc.Etag != "a" || len(pdoc.Funcs) != len(docs[0].Funcs) {
+			t.Errorf("size %d: got etag %q, %d funcs", size, pdoc.Etag, len(pdoc.Funcs))
+		}
+	}
+
+	if chunks := splitDoc(nil, 10); len(chunks) != 1 {
+		t.Errorf("splitDoc(nil) returned %d chunks, want 1", len(chunks))
+	}
+
+	// The first chunk of one version with the remaining chunks of another
+	// version, as read during a concurrent update, is not decoded.
+	size := len(encoded[0]) / 3
+	prev, next := splitDoc(encoded[0], size), splitDoc(encoded[1], size)
+	mixed := append([][]byte{prev[0]}, next[1:]...)
+	if _, err := decodeDoc(joinDoc(mixed)); err == nil {
+		t.Error("decodeDoc of mixed chunks returned nil error")
+	}
+}
@@ -23,6 +23,8 @@ import (
 	"errors"
 	"fmt"
 	godoc "go/doc"
+	"go/scanner"
+	"go/token"
 	"net/http"
 	"net/url"
 	"path"
@@ -136,6 +138,101 @@ func declFmt(decl doc.Decl) string {
 	return buf.String()
 }
 
+// sourceFmt formats the source code for a file as HTML. Lines are numbered
+// with anchors of the form #L123, comments, literals and keywords are
+// highlighted and identifiers link to their documentation.
+func sourceFmt(pdoc *doc.Package, file *doc.File) string {
+	src := []byte(file.Source.Text)
+
+	annotations := make(map[int]doc.TypeAnnotation)
+	for _, a := range file.Source.Annotations {
+		annotations[a.Pos] = a
+	}
+
+	var buf bytes.Buffer
+	line := 1
+	lastLine := bytes.Count(src, []byte{'\n'})
+	writeLineNumber := func() {
+		fmt.Fprintf(&buf, `<a id="L%d" href="#L%d" class="ln">%4d</a>  `, line, line, line)
+		line++
+	}
+	writeText := func(p []byte) {
+		for {
+			i := bytes.IndexByte(p, '\n')
+			if i < 0 {
+				template.HTMLEscape(&buf, p)
+				return
+			}
+			template.HTMLEscape(&buf, p[:i+1])
+			p = p[i+1:]
+			if len(p) > 0 || line <= lastLine {
+				writeLineNumber()
+			}
+		}
+	}
+
+	writeLineNumber()
+
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	s.Init(fset.AddFile(file.Name, -1, len(src)), src, nil, scanner.ScanComments)
+	last := 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		offset := fset.Position(pos).Offset
+		if offset < last || offset+len(lit) > len(src) || string(src[offset:offset+len(lit)]) != lit {
+			continue
+		}
+
+		var class string
+		switch {
+		case tok == token.COMMENT:
+			class = "com"
+		case tok == token.STRING || tok == token.CHAR:
+			class = "lit"
+		case tok.IsKeyword():
+			class = "kw"
+		case tok == token.IDENT:
+			if a, ok := annotations[offset]; ok && a.End == offset+len(lit) {
+				writeText(src[last:offset])
+				p := pdoc.ImportPath
+				if a.ImportPath != "" {
+					p = a.ImportPath
+				}
+				buf.WriteString(`<a href="/`)
+				buf.WriteString(urlFmt(p))
+				buf.WriteByte('#')
+				buf.WriteString(urlFmt(a.Name))
+				buf.WriteString(`">`)
+				writeText(src[offset:a.End])
+				buf.WriteString(`</a>`)
+				last = a.End
+			}
+			continue
+		default:
+			continue
+		}
+
+		writeText(src[last:offset])
+		buf.WriteString(`<span class="`)
+		buf.WriteString(class)
+		buf.WriteString(`">`)
+		writeText(src[offset : offset+len(lit)])
+		buf.WriteString(`</span>`)
+		last = offset + len(lit)
+	}
+	writeText(src[last:])
+	return buf.String()
+}
+
+// sourceURLFmt returns the URL of the GoPkgDoc source view for a file.
+func sourceURLFmt(pdoc *doc.Package, file *doc.File) string {
+	return "/-/src/" + urlFmt(pdoc.ImportPath+"/"+file.Name)
+}
+
 func commandNameFmt(pdoc *doc.Package) string {
 	_, name := path.Split(pdoc.ImportPath)
 	return template.HTMLEscapeString(name)
@@ -200,9 +297,12 @@ func parseTemplates() (*template.Template, error) {
 		"equal":        reflect.DeepEqual,
 		"map":          mapFmt,
 		"breadcrumbs":  breadcrumbsFmt,
+		"canRun":       func() bool { return exampleRunnerURL != "" },
 		"commandName":  commandNameFmt,
 		"relativePath": relativePathFmt,
 		"relativeTime": relativeTime,
+		"source":       sourceFmt,
+		"sourceURL":    sourceURLFmt,
 		"importPath":   importPathFmt,
 		"url":          urlFmt,
 	})
@@ -211,4 +311,7 @@ func parseTemplates() (*template.Template, error) {
 
 func init() {
 	templateSet = template.Must(parseTemplates())
+	if err := doc.LoadAPIHistory("api"); err != nil {
+		panic(err)
+	}
 }
@@ -20,10 +20,25 @@ import (
 	"appengine"
 	"appengine/memcache"
 	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"net/http"
 	"time"
 )
 
+// requestContext returns a context with the deadline d that is canceled when
+// the request of c is canceled.
+func requestContext(c appengine.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if r, ok := c.Request().(*http.Request); ok {
+		ctx = r.Context()
+	}
+	return context.WithTimeout(ctx, d)
+}
+
 func cacheGet(c appengine.Context, key string, object interface{}) (*memcache.Item, error) {
 	item, err := memcache.Get(c, key)
 	switch {
@@ -86,3 +101,12 @@ func cacheClear(c appengine.Context, keys ...string) error {
 	}
 	return memcache.SetMulti(c, items)
 }
+
+// newToken returns a random hex string.
+func newToken() (string, error) {
+	p := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, p); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(p), nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// Diagnostic is a problem found in the package source by an analyzer.
+type Diagnostic struct {
+	// Name of the analyzer that reported the problem.
+	Analyzer string
+
+	Severity Severity
+	Message  string
+
+	// Position of the problem in the source.
+	File   string
+	Line   int
+	Column int
+
+	// Link to the source on the project site or "" if not available.
+	URL string
+}
+
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s (%s)", d.File, d.Line, d.Column, d.Severity, d.Message, d.Analyzer)
+}
+
+// Analyzer is a check run over the package source when building the
+// documentation.
+type Analyzer struct {
+	// Name of the analyzer. The name is recorded in diagnostics.
+	Name string
+
+	// Run inspects the package and reports diagnostics with the pass.
+	Run func(pass *Pass)
+}
+
+var analyzers []*Analyzer
+
+// RegisterAnalyzer adds an analyzer to the set of analyzers run on packages.
+// Analyzers must be registered before calling Get.
+func RegisterAnalyzer(a *Analyzer) {
+	analyzers = append(analyzers, a)
+}
+
+// Pass provides an analyzer with the parsed package and collects the
+// analyzer's diagnostics.
+type Pass struct {
+	Fset       *token.FileSet
+	ImportPath string
+
+	// The package AST. Function bodies are present.
+	Package *ast.Package
+
+	b        *builder
+	analyzer *Analyzer
+	seen     map[string]bool
+}
+
+// FileNames returns the sorted names of the files in the package. Analyzers
+// visit the files in this order so that duplicate diagnostics are reported
+// at the same position on every run.
+func (p *Pass) FileNames() []string {
+	var names []string
+	for name := range p.Package.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FileImportPaths returns a package name to import path map for the file with
+// filename.
+func (p *Pass) FileImportPaths(filename string) map[string]string {
+	return p.b.fileImportPaths(filename)
+}
+
+// Reportf reports a diagnostic at pos. Duplicate messages are reported once.
+func (p *Pass) Reportf(pos token.Pos, severity Severity, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if p.seen[message] {
+		return
+	}
+	p.seen[message] = true
+	position := p.Fset.Position(pos)
+	p.b.pkg.Diagnostics = append(p.b.pkg.Diagnostics, Diagnostic{
+		Analyzer: p.analyzer.Name,
+		Severity: severity,
+		Message:  message,
+		File:     position.Filename,
+		Line:     position.Line,
+		Column:   position.Column,
+		URL:      p.b.printPos(pos),
+	})
+}
+
+type byPosition []Diagnostic
+
+func (p byPosition) Len() int      { return len(p) }
+func (p byPosition) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byPosition) Less(i, j int) bool {
+	switch {
+	case p[i].File != p[j].File:
+		return p[i].File < p[j].File
+	case p[i].Line != p[j].Line:
+		return p[i].Line < p[j].Line
+	}
+	return p[i].Column < p[j].Column
+}
+
+// analyze runs the registered analyzers over the package.
+func (b *builder) analyze() {
+	for _, a := range analyzers {
+		pass := &Pass{
+			Fset:       b.fset,
+			ImportPath: b.pkg.ImportPath,
+			Package:    b.ast,
+			b:          b,
+			analyzer:   a,
+			seen:       make(map[string]bool),
+		}
+		a.Run(pass)
+	}
+	sort.Stable(byPosition(b.pkg.Diagnostics))
+}
@@ -15,6 +15,7 @@
 package doc
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"path"
@@ -23,7 +24,7 @@ import (
 
 var bitbucketPattern = regexp.MustCompile(`^bitbucket\.org/([a-z0-9A-Z_.\-]+)/([a-z0-9A-Z_.\-]+)(/[a-z0-9A-Z_.\-/]*)?$`)
 
-func getBitbucketDoc(client *http.Client, m []string, savedEtag string) (*Package, error) {
+func getBitbucketDoc(ctx context.Context, client *http.Client, m []string, savedEtag string) (*Package, error) {
 
 	importPath := m[0]
 	projectRoot := "bitbucket.org/" + m[1] + "/" + m[2]
@@ -36,10 +37,10 @@ func getBitbucketDoc(client *http.Client, m []string, savedEtag string) (*Packag
 	// tag.  Mercurial repositories use the tag "tip". Git repositories use the
 	// tag "master".
 	tag := "tip"
-	p, etag, err := httpGetBytesCompare(client, "https://api.bitbucket.org/1.0/repositories/"+userRepo+"/src/"+tag+"/"+dir, savedEtag)
+	p, etag, err := httpGetBytesCompare(ctx, client, "https://api.bitbucket.org/1.0/repositories/"+userRepo+"/src/"+tag+"/"+dir, savedEtag)
 	if err == ErrPackageNotFound {
 		tag = "master"
-		p, etag, err = httpGetBytesCompare(client, "https://api.bitbucket.org/1.0/repositories/"+userRepo+"/src/"+tag+"/"+dir, savedEtag)
+		p, etag, err = httpGetBytesCompare(ctx, client, "https://api.bitbucket.org/1.0/repositories/"+userRepo+"/src/"+tag+"/"+dir, savedEtag)
 	}
 	if err != nil {
 		return nil, err
@@ -51,7 +52,7 @@ func getBitbucketDoc(client *http.Client, m []string, savedEtag string) (*Packag
 		}
 	}
 	if err := json.Unmarshal(p, &directory); err != nil {
-		return nil, err
+		return nil, parseError("https://api.bitbucket.org", err)
 	}
 
 	var files []*source
@@ -66,9 +67,20 @@ func getBitbucketDoc(client *http.Client, m []string, savedEtag string) (*Packag
 		}
 	}
 
-	if err := fetchFiles(client, files, nil); err != nil {
+	if err := fetchFiles(ctx, client, files, nil); err != nil {
+		return nil, err
+	}
+
+	src, err := fetchGoMod(ctx, client, dir, nil, func(p string) (string, string) {
+		return "https://api.bitbucket.org/1.0/repositories/" + userRepo + "/raw/" + tag + "/" + p,
+			"https://bitbucket.org/" + userRepo + "/src/" + tag + "/" + p
+	})
+	if err != nil {
 		return nil, err
 	}
+	if src != nil {
+		files = append(files, src)
+	}
 
 	return buildDoc(importPath, projectRoot, projectName, projectURL, etag, "#cl-%d", files)
 }
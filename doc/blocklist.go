@@ -0,0 +1,112 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrBlocked is returned by Get for import paths on the blocklist.
+var ErrBlocked = errors.New("import path blocked")
+
+// Blocklist is a list of blocked hosts, import path prefixes and regular
+// expressions. Entries have the form "host:example.com",
+// "prefix:github.com/user" or "regexp:^github\.com/[^/]+/spam". An entry
+// without a kind is a prefix. A host entry also blocks subdomains of the
+// host.
+type Blocklist struct {
+	hosts    []string
+	prefixes []string
+	patterns []*regexp.Regexp
+}
+
+// NewBlocklist returns the blocklist for the given entries.
+func NewBlocklist(entries []string) (*Blocklist, error) {
+	b := &Blocklist{}
+	for _, e := range entries {
+		kind, value := "prefix", e
+		if i := strings.Index(e, ":"); i >= 0 {
+			kind, value = e[:i], e[i+1:]
+		}
+		if value == "" {
+			return nil, fmt.Errorf("empty blocklist entry %q", e)
+		}
+		switch kind {
+		case "host":
+			b.hosts = append(b.hosts, strings.ToLower(value))
+		case "prefix":
+			b.prefixes = append(b.prefixes, strings.TrimSuffix(value, "/"))
+		case "regexp":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("blocklist entry %q: %v", e, err)
+			}
+			b.patterns = append(b.patterns, re)
+		default:
+			return nil, fmt.Errorf("unknown blocklist entry kind %q", kind)
+		}
+	}
+	return b, nil
+}
+
+// Blocked returns true if importPath matches an entry in the blocklist.
+func (b *Blocklist) Blocked(importPath string) bool {
+	if b == nil {
+		return false
+	}
+	host := strings.ToLower(strings.SplitN(importPath, "/", 2)[0])
+	for _, h := range b.hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	for _, p := range b.prefixes {
+		if importPath == p || strings.HasPrefix(importPath, p+"/") {
+			return true
+		}
+	}
+	for _, re := range b.patterns {
+		if re.MatchString(importPath) {
+			return true
+		}
+	}
+	return false
+}
+
+var blocklist struct {
+	sync.RWMutex
+	b *Blocklist
+}
+
+// SetBlocklist sets the blocklist checked by Get. SetBlocklist can be called
+// while other goroutines are calling Get.
+func SetBlocklist(b *Blocklist) {
+	blocklist.Lock()
+	blocklist.b = b
+	blocklist.Unlock()
+}
+
+// IsBlocked returns true if importPath is on the blocklist set with
+// SetBlocklist.
+func IsBlocked(importPath string) bool {
+	blocklist.RLock()
+	b := blocklist.b
+	blocklist.RUnlock()
+	return b.Blocked(importPath)
+}
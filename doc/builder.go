@@ -320,22 +320,40 @@ func (b *builder) printPos(pos token.Pos) string {
 		// src can be nil when line comments are used (//line <file>:<line>).
 		return ""
 	}
+	if b.lineFmt == "" {
+		// The project's source browser does not link to lines.
+		return src.browseURL
+	}
 	return src.browseURL + fmt.Sprintf(b.lineFmt, position.Line)
 }
 
+// deprecated returns the paragraph starting with "Deprecated: " in the doc
+// comment s or "" if there is no such paragraph.
+func deprecated(s string) string {
+	for _, p := range strings.Split(s, "\n\n") {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "Deprecated: ") {
+			return p
+		}
+	}
+	return ""
+}
+
 type Value struct {
-	Decl Decl
-	URL  string
-	Doc  string
+	Decl       Decl
+	URL        string
+	Doc        string
+	Deprecated string
 }
 
 func (b *builder) values(vdocs []*doc.Value) []*Value {
 	var result []*Value
 	for _, d := range vdocs {
 		result = append(result, &Value{
-			Decl: b.printDecl(d.Decl),
-			URL:  b.printPos(d.Decl.Pos()),
-			Doc:  d.Doc,
+			Decl:       b.printDecl(d.Decl),
+			URL:        b.printPos(d.Decl.Pos()),
+			Doc:        d.Doc,
+			Deprecated: deprecated(d.Doc),
 		})
 	}
 	return result
@@ -346,6 +364,15 @@ type Example struct {
 	Doc    string
 	Code   string
 	Output string
+
+	// Play is a complete program for the example or "" if the example is not
+	// runnable. PlayOutput is the expected output of the program or "" if the
+	// output is not checked.
+	Play       string
+	PlayOutput string
+
+	// Errors found when verifying the example against the package API.
+	Errors []string
 }
 
 var exampleOutputRx = regexp.MustCompile(`(?i)//[[:space:]]*output:`)
@@ -383,18 +410,31 @@ func (b *builder) getExamples(name string) []Example {
 			// drop output, as the output comment will appear in the code
 			output = ""
 		}
-		docs = append(docs, Example{Name: e.Name, Doc: e.Doc, Code: code, Output: output})
+		var play string
+		if e.Play != nil {
+			play = b.printNode(e.Play)
+		}
+
+		docs = append(docs, Example{
+			Name:       e.Name,
+			Doc:        e.Doc,
+			Code:       code,
+			Output:     output,
+			Play:       play,
+			PlayOutput: e.Output,
+		})
 	}
 	return docs
 }
 
 type Func struct {
-	Decl     Decl
-	URL      string
-	Doc      string
-	Name     string
-	Recv     string
-	Examples []Example
+	Decl       Decl
+	URL        string
+	Doc        string
+	Deprecated string
+	Name       string
+	Recv       string
+	Examples   []Example
 }
 
 func (b *builder) funcs(fdocs []*doc.Func) []*Func {
@@ -410,68 +450,221 @@ func (b *builder) funcs(fdocs []*doc.Func) []*Func {
 			exampleName = d.Recv + "_" + d.Name
 		}
 		result = append(result, &Func{
-			Decl:     b.printDecl(d.Decl),
-			URL:      b.printPos(d.Decl.Pos()),
-			Doc:      d.Doc,
-			Name:     d.Name,
-			Recv:     d.Recv,
-			Examples: b.getExamples(exampleName),
+			Decl:       b.printDecl(d.Decl),
+			URL:        b.printPos(d.Decl.Pos()),
+			Doc:        d.Doc,
+			Deprecated: deprecated(d.Doc),
+			Name:       d.Name,
+			Recv:       d.Recv,
+			Examples:   b.getExamples(exampleName),
 		})
 	}
 	return result
 }
 
 type Type struct {
-	Doc      string
-	Name     string
-	Decl     Decl
-	URL      string
-	Consts   []*Value
-	Vars     []*Value
-	Funcs    []*Func
-	Methods  []*Func
-	Examples []Example
+	Doc        string
+	Deprecated string
+	Name       string
+	Decl       Decl
+	URL        string
+	Consts     []*Value
+	Vars       []*Value
+	Funcs      []*Func
+	Methods    []*Func
+	Examples   []Example
 }
 
 func (b *builder) types(tdocs []*doc.Type) []*Type {
 	var result []*Type
 	for _, d := range tdocs {
 		result = append(result, &Type{
-			Doc:      d.Doc,
-			Name:     d.Name,
-			Decl:     b.printDecl(d.Decl),
-			URL:      b.printPos(d.Decl.Pos()),
-			Consts:   b.values(d.Consts),
-			Vars:     b.values(d.Vars),
-			Funcs:    b.funcs(d.Funcs),
-			Methods:  b.funcs(d.Methods),
-			Examples: b.getExamples(d.Name),
+			Doc:        d.Doc,
+			Deprecated: deprecated(d.Doc),
+			Name:       d.Name,
+			Decl:       b.printDecl(d.Decl),
+			URL:        b.printPos(d.Decl.Pos()),
+			Consts:     b.values(d.Consts),
+			Vars:       b.values(d.Vars),
+			Funcs:      b.funcs(d.Funcs),
+			Methods:    b.funcs(d.Methods),
+			Examples:   b.getExamples(d.Name),
 		})
 	}
 	return result
 }
 
+type Note struct {
+	UID  string
+	Body string
+	URL  string
+}
+
+func (b *builder) notes(ndocs map[string][]*doc.Note) map[string][]*Note {
+	if len(ndocs) == 0 {
+		return nil
+	}
+	result := make(map[string][]*Note)
+	for marker, notes := range ndocs {
+		for _, n := range notes {
+			result[marker] = append(result[marker], &Note{
+				UID:  n.UID,
+				Body: n.Body,
+				URL:  b.printPos(n.Pos),
+			})
+		}
+	}
+	return result
+}
+
 type File struct {
 	Name string
 	URL  string
+
+	// Source code for the file. The annotations link identifiers to their
+	// documentation.
+	Source Decl
+}
+
+// sourceVisitor collects annotations for the identifiers in a source file.
+type sourceVisitor struct {
+	annotations []TypeAnnotation
+	fset        *token.FileSet
+	importPaths map[string]string
+	exports     map[string]bool
 }
 
-func (b *builder) files(names []string) []*File {
+func (v *sourceVisitor) Visit(n ast.Node) ast.Visitor {
+	switch n := n.(type) {
+	case *ast.FuncDecl:
+		if n.Recv != nil {
+			ast.Walk(v, n.Recv)
+			if name := recvTypeName(n.Recv); v.exports[name] && ast.IsExported(n.Name.Name) {
+				v.addAnnotation(n.Name, "", name+"."+n.Name.Name)
+			}
+		} else {
+			ast.Walk(v, n.Name)
+		}
+		ast.Walk(v, n.Type)
+		if n.Body != nil {
+			ast.Walk(v, n.Body)
+		}
+		return nil
+	case *ast.SelectorExpr:
+		if x, ok := n.X.(*ast.Ident); ok && x.Obj == nil && ast.IsExported(n.Sel.Name) {
+			if importPath := v.importPaths[x.Name]; importPath != "" {
+				v.addAnnotation(n.Sel, importPath, n.Sel.Name)
+				return nil
+			}
+		}
+		// Do not link field and method selectors to top-level declarations.
+		ast.Walk(v, n.X)
+		return nil
+	case *ast.KeyValueExpr:
+		// Keys in composite literals are usually field names.
+		ast.Walk(v, n.Value)
+		return nil
+	case *ast.Field:
+		// Do not link field and parameter names.
+		ast.Walk(v, n.Type)
+		return nil
+	case *ast.Ident:
+		if v.exports[n.Name] {
+			v.addAnnotation(n, "", n.Name)
+		}
+		return nil
+	}
+	return v
+}
+
+func (v *sourceVisitor) addAnnotation(n ast.Node, importPath string, name string) {
+	v.annotations = append(v.annotations, TypeAnnotation{
+		v.fset.Position(n.Pos()).Offset,
+		v.fset.Position(n.End()).Offset,
+		importPath,
+		name})
+}
+
+// recvTypeName returns the name of the receiver type.
+func recvTypeName(recv *ast.FieldList) string {
+	if len(recv.List) != 1 {
+		return ""
+	}
+	t := recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if id, ok := t.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// sourceAnnotations finds the identifiers in the package source files that
+// link to documentation. This method must be called before doc.New trims the
+// function bodies from the AST.
+func (b *builder) sourceAnnotations() map[string][]TypeAnnotation {
+	exports := make(map[string]bool)
+	for _, file := range b.ast.Files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					exports[decl.Name.Name] = ast.IsExported(decl.Name.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						exports[spec.Name.Name] = ast.IsExported(spec.Name.Name)
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							exports[name.Name] = ast.IsExported(name.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	result := make(map[string][]TypeAnnotation)
+	for name, file := range b.ast.Files {
+		v := &sourceVisitor{
+			fset:        b.fset,
+			importPaths: b.fileImportPaths(name),
+			exports:     exports,
+		}
+		ast.Walk(v, file)
+		sort.Sort(sortByPos(v.annotations))
+		result[name] = v.annotations
+	}
+	return result
+}
+
+func (b *builder) files(names []string, annotations map[string][]TypeAnnotation) []*File {
 	var result []*File
 	for _, name := range names {
+		src := b.srcs[name]
 		result = append(result, &File{
-			Name: name,
-			URL:  b.srcs[name].browseURL,
+			Name:   name,
+			URL:    src.browseURL,
+			Source: Decl{Text: string(src.data), Annotations: annotations[name]},
 		})
 	}
 	return result
 }
 
+// source is a file fetched from the VCS. The go.mod file for the package is
+// included in the sources of some fetchers. See isGoModSource.
 type source struct {
 	name      string
 	browseURL string
 	rawURL    string
 	data      []byte
+
+	// Error from reading the file. The file is omitted from the
+	// documentation.
+	err error
 }
 
 func (s *source) Name() string       { return s.name }
@@ -487,6 +680,9 @@ func (b *builder) readDir(dir string) ([]os.FileInfo, error) {
 	}
 	infos := make([]os.FileInfo, 0, len(b.srcs))
 	for _, src := range b.srcs {
+		if isGoModSource(src.name) {
+			continue
+		}
 		infos = append(infos, src)
 	}
 	return infos, nil
@@ -502,7 +698,7 @@ func (b *builder) openFile(path string) (io.ReadCloser, error) {
 }
 
 // PackageVersion is modified when previously stored packages are invalid.
-const PackageVersion = "3"
+const PackageVersion = "6"
 
 type Package struct {
 	// The import path for this package.
@@ -520,6 +716,29 @@ type Package struct {
 	// Errors found when fetching or parsing this package. 
 	Errors []string
 
+	// Problems found in the package source by the registered analyzers.
+	Diagnostics []Diagnostic
+
+	// Minimum Go version required by the package's use of the standard
+	// library API or "" if not known.
+	MinGoVersion string
+
+	// Information from the nearest go.mod file or nil if the package is not
+	// in a module.
+	Module *Module
+
+	// True if the package was fetched with credentials from the credentials
+	// provider.
+	Private bool
+
+	// Import path from the import comment on the package clause or "".
+	ImportComment string
+
+	// Import path declared by the module path or import comment when it
+	// differs from ImportPath, otherwise "". Forks and mirrors of a package
+	// have a canonical import path.
+	CanonicalImportPath string
+
 	// The time this object was created.
 	Updated time.Time
 
@@ -535,6 +754,9 @@ type Package struct {
 	Synopsis string
 	Doc      string
 
+	// The "Deprecated: " paragraph from the package documentation.
+	Deprecated string
+
 	// Format this package as a command.
 	IsCmd bool
 
@@ -547,9 +769,16 @@ type Package struct {
 	// Package examples
 	Examples []Example
 
+	// Notes of the form MARKER(uid): body collected from comments, indexed
+	// by marker. BUG(uid): notes are stored under the marker "BUG".
+	Notes map[string][]*Note
+
 	// Source files.
 	Files []*File
 
+	// Documentation quality metrics or nil for commands.
+	Quality *Quality
+
 	// Imports
 	Imports     []string
 	TestImports []string
@@ -576,7 +805,15 @@ func buildDoc(importPath, projectRoot, projectName, projectURL, etag string, lin
 		return b.pkg, nil
 	}
 
+	seen := make(map[error]bool)
 	for _, src := range srcs {
+		if src.err != nil {
+			if !seen[src.err] {
+				seen[src.err] = true
+				b.pkg.Errors = append(b.pkg.Errors, "Documentation is incomplete: "+src.err.Error())
+			}
+			continue
+		}
 		b.srcs[src.name] = src
 	}
 
@@ -589,7 +826,7 @@ func buildDoc(importPath, projectRoot, projectName, projectURL, etag string, lin
 		JoinPath:      path.Join,
 		IsAbsPath:     path.IsAbs,
 		SplitPathList: func(list string) []string { return strings.Split(list, ":") },
-		IsDir:         func(path string) bool { panic("unexpected") },
+		IsDir:         func(path string) bool { return path == b.pkg.ImportPath },
 		HasSubdir:     func(root, dir string) (rel string, ok bool) { panic("unexpected") },
 		ReadDir:       func(dir string) (fi []os.FileInfo, err error) { return b.readDir(dir) },
 		OpenFile:      func(path string) (r io.ReadCloser, err error) { return b.openFile(path) },
@@ -631,22 +868,31 @@ func buildDoc(importPath, projectRoot, projectName, projectURL, etag string, lin
 		b.examples = append(b.examples, doc.Examples(file)...)
 	}
 
-	b.vetPackage()
+	b.module()
+	b.pkg.ImportComment = b.importComment()
+	b.analyze()
+	b.pkg.MinGoVersion = b.minGoVersion()
+	annotations := b.sourceAnnotations()
 
 	pdoc := doc.New(b.ast, b.pkg.ImportPath, 0)
 
 	b.pkg.Name = pdoc.Name
 	b.pkg.Doc = strings.TrimRight(pdoc.Doc, " \t\n\r")
 	b.pkg.Synopsis = synopsis(b.pkg.Doc)
+	b.pkg.Deprecated = deprecated(b.pkg.Doc)
 
 	b.pkg.Examples = b.getExamples("")
-	b.pkg.Files = b.files(pdoc.Filenames)
+	b.pkg.Files = b.files(pdoc.Filenames, annotations)
 	b.pkg.IsCmd = pkg.IsCommand()
 
 	b.pkg.Consts = b.values(pdoc.Consts)
 	b.pkg.Funcs = b.funcs(pdoc.Funcs)
 	b.pkg.Types = b.types(pdoc.Types)
 	b.pkg.Vars = b.values(pdoc.Vars)
+	b.pkg.Notes = b.notes(pdoc.Notes)
+	if !b.pkg.IsCmd {
+		b.pkg.Quality = b.quality(pdoc)
+	}
 
 	b.pkg.Imports = pkg.Imports
 	b.pkg.TestImports = pkg.TestImports
@@ -0,0 +1,113 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"go/ast"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterAnalyzer(&Analyzer{Name: "importcomment", Run: checkImportComments})
+}
+
+// importComment returns the import path from an import comment on the
+// package clause of file. An import comment has the form
+//
+//	package foo // import "example.com/foo"
+func importComment(fset *token.FileSet, file *ast.File) (string, token.Pos) {
+	line := fset.Position(file.Name.End()).Line
+	for _, g := range file.Comments {
+		if g.Pos() < file.Name.End() {
+			continue
+		}
+		if fset.Position(g.Pos()).Line != line {
+			break
+		}
+		text := g.List[0].Text
+		if strings.HasPrefix(text, "//") {
+			text = text[2:]
+		} else {
+			text = strings.TrimSuffix(text[2:], "*/")
+		}
+		text = strings.TrimSpace(text)
+		if !strings.HasPrefix(text, "import ") {
+			break
+		}
+		p, err := strconv.Unquote(strings.TrimSpace(text[len("import "):]))
+		if err != nil {
+			break
+		}
+		return p, g.Pos()
+	}
+	return "", token.NoPos
+}
+
+// importComment returns the first import comment in the package files. The
+// files are visited in sorted order.
+func (b *builder) importComment() string {
+	var names []string
+	for name := range b.ast.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if comment, _ := importComment(b.fset, b.ast.Files[name]); comment != "" {
+			return comment
+		}
+	}
+	return ""
+}
+
+// checkImportComments reports files with conflicting import comments.
+func checkImportComments(pass *Pass) {
+	first, firstComment := "", ""
+	for _, name := range pass.FileNames() {
+		comment, pos := importComment(pass.Fset, pass.Package.Files[name])
+		switch {
+		case comment == "":
+			// No comment.
+		case first == "":
+			first, firstComment = name, comment
+		case comment != firstComment:
+			pass.Reportf(pos, SeverityWarning, "import comment %q conflicts with import comment %q in %s", comment, firstComment, first)
+		}
+	}
+}
+
+// setCanonicalImportPath sets the package's canonical import path when the
+// module path or import comment disagrees with the import path used to fetch
+// the package. The go command ignores import comments in module mode, so the
+// module path takes precedence. A module path that differs from the import
+// path only by the major version suffix is not a different import path.
+func setCanonicalImportPath(pdoc *Package) {
+	canonical := ""
+	switch {
+	case pdoc.ProjectRoot == "":
+		// Standard packages.
+	case pdoc.Module != nil && pdoc.Module.Path != "":
+		if !matchesModulePath(pdoc.Module, pdoc.ImportPath) {
+			canonical = modulePackagePath(pdoc.Module)
+		}
+	default:
+		canonical = pdoc.ImportComment
+	}
+	if canonical != "" && canonical != pdoc.ImportPath {
+		pdoc.CanonicalImportPath = canonical
+	}
+}
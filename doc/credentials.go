@@ -0,0 +1,157 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Credentials authenticate requests to a host. If Token is not "", the
+// Authorization header is set to Scheme followed by Token. Otherwise, the
+// request uses basic authentication with Username and Password.
+type Credentials struct {
+	// Import path prefix of the packages the credentials are used for. The
+	// credentials are used for all packages if Prefix is "".
+	Prefix string
+
+	// Host of the requests the credentials are sent to, for example
+	// "api.github.com".
+	Host string
+
+	// Authorization scheme. The default is "Bearer".
+	Scheme string
+	Token  string
+
+	Username string
+	Password string
+
+	// Documentation fetched with the credentials is private. Set Private
+	// for credentials that are required to read the repository. Leave
+	// Private false for credentials that only raise rate limits on public
+	// repositories.
+	Private bool
+}
+
+// match returns true if the credentials apply to a request to host made
+// while fetching the package with importPath.
+func (c *Credentials) match(importPath, host string) bool {
+	if c.Host != host {
+		return false
+	}
+	prefix := strings.TrimSuffix(c.Prefix, "/")
+	return prefix == "" || importPath == prefix || strings.HasPrefix(importPath, prefix+"/")
+}
+
+// CredentialsProvider supplies the credentials for requests made while
+// fetching a package.
+type CredentialsProvider interface {
+	// Credentials returns the credentials for a request to host made while
+	// fetching the package with importPath or nil if the request is not
+	// authenticated.
+	Credentials(importPath, host string) *Credentials
+}
+
+// CredentialsList is a CredentialsProvider with a fixed list of credentials.
+// The credentials with the longest matching prefix are used.
+type CredentialsList []*Credentials
+
+func (l CredentialsList) Credentials(importPath, host string) *Credentials {
+	var result *Credentials
+	for _, c := range l {
+		if c.match(importPath, host) && (result == nil || len(c.Prefix) > len(result.Prefix)) {
+			result = c
+		}
+	}
+	return result
+}
+
+var credentialsProvider CredentialsProvider
+
+// SetCredentialsProvider sets the provider of credentials for fetching
+// private packages. Documentation fetched with credentials that have the
+// Private flag set is marked as private. SetCredentialsProvider must be
+// called before calling Get.
+func SetCredentialsProvider(p CredentialsProvider) {
+	credentialsProvider = p
+}
+
+// credentialsTransport adds credentials to the requests made while fetching
+// a package.
+type credentialsTransport struct {
+	base       http.RoundTripper
+	importPath string
+
+	// Set to 1 when a request is sent with private credentials. Files are
+	// fetched concurrently.
+	private int32
+}
+
+func (t *credentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		// The service is configured with a token.
+		return t.base.RoundTrip(req)
+	}
+	c := credentialsProvider.Credentials(t.importPath, req.URL.Host)
+	if c == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the request.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header)
+	for k, vs := range req.Header {
+		r.Header[k] = vs
+	}
+	if c.Token != "" {
+		scheme := c.Scheme
+		if scheme == "" {
+			scheme = "Bearer"
+		}
+		r.Header.Set("Authorization", scheme+" "+c.Token)
+	} else {
+		r.SetBasicAuth(c.Username, c.Password)
+	}
+	if c.Private {
+		atomic.StoreInt32(&t.private, 1)
+	}
+	return t.base.RoundTrip(r)
+}
+
+// isPrivate returns true if a request was sent with private credentials.
+func (t *credentialsTransport) isPrivate() bool {
+	return t != nil && atomic.LoadInt32(&t.private) != 0
+}
+
+// withCredentials returns a client that adds credentials to requests made
+// while fetching importPath. The credentials provider is called with
+// importPath for all requests, including requests for packages found through
+// go-import meta tags. The transport is nil if there is no credentials
+// provider.
+func withCredentials(client *http.Client, importPath string) (*http.Client, *credentialsTransport) {
+	if credentialsProvider == nil {
+		return client, nil
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &credentialsTransport{base: base, importPath: importPath}
+	c := *client
+	c.Transport = t
+	return &c, t
+}
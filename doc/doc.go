@@ -15,9 +15,12 @@
 package doc
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"path"
 	"regexp"
 	"strings"
@@ -28,17 +31,45 @@ import (
 // service represents a source code control service.
 type service struct {
 	pattern *regexp.Regexp
-	getDoc  func(*http.Client, []string, string) (*Package, error)
+	getDoc  func(context.Context, *http.Client, []string, string) (*Package, error)
 	prefix  string
 }
 
 // services is the list of source code control services handled by gopkgdoc.
 var services = []*service{
-	&service{githubPattern, getGithubDoc, "github.com/"},
+	&service{githubPattern, githubDotCom.getDoc, "github.com/"},
 	&service{googlePattern, getGoogleDoc, "code.google.com/"},
 	&service{bitbucketPattern, getBitbucketDoc, "bitbucket.org/"},
 	&service{launchpadPattern, getLaunchpadDoc, "launchpad.net/"},
 	&service{gitoriousPattern, getGitoriousDoc, "git.gitorious.org/"},
+	&service{gitlabPattern, gitlabDotCom.getDoc, "gitlab.com/"},
+}
+
+// ownerRepoPattern returns the pattern for import paths of the form
+// host/owner/repo/dir.
+func ownerRepoPattern(host string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(host) + `/([a-z0-9A-Z_.\-]+)/([a-z0-9A-Z_.\-]+)(/[a-z0-9A-Z_.\-/]*)?$`)
+}
+
+// addService adds a service to the list of services. The service replaces an
+// existing service with the same prefix.
+func addService(svc *service) {
+	for i := range services {
+		if services[i].prefix == svc.prefix {
+			services[i] = svc
+			return
+		}
+	}
+	services = append(services, svc)
+}
+
+// tokenHeader returns the request header for sending token in an
+// Authorization header with the given scheme or nil if token is "".
+func tokenHeader(scheme, token string) http.Header {
+	if token == "" {
+		return nil
+	}
+	return http.Header{"Authorization": {scheme + " " + token}}
 }
 
 func attrValue(attrs []xml.Attr, name string) string {
@@ -50,9 +81,59 @@ func attrValue(attrs []xml.Attr, name string) string {
 	return ""
 }
 
-func getMeta(client *http.Client, importPath string) (projectRoot, projectName, projectURL, repoRoot string, err error) {
+// goSource is the information from a go-source meta tag. The fields are the
+// URL templates for the project home page, a directory and a file. A template
+// is "" if the project does not provide the page.
+type goSource struct {
+	home, dir, file string
+}
+
+// expandGoSource expands the {dir}, {/dir} and {file} variables in template t.
+func expandGoSource(t, dir, file string) string {
+	slashDir := ""
+	if dir != "" {
+		slashDir = "/" + dir
+	}
+	return strings.NewReplacer("{dir}", dir, "{/dir}", slashDir, "{file}", file).Replace(t)
+}
+
+// isWebURL returns true if the go-source template t expands to an absolute
+// http or https URL. The URLs are used as links in the documentation.
+func isWebURL(t string) bool {
+	u, err := url.Parse(strings.NewReplacer("{dir}", "d", "{/dir}", "/d", "{file}", "f", "{line}", "1").Replace(t))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// fileURL returns the browse URL and line format for file in directory dir
+// relative to the project root. The line format is "" if the file template
+// does not have a {line} variable.
+func (s *goSource) fileURL(dir, file string) (browseURL, lineFmt string) {
+	t := expandGoSource(s.file, dir, file)
+	i := strings.Index(t, "{line}")
+	if i < 0 {
+		return t, ""
+	}
+	j := strings.LastIndexAny(t[:i], "#?&")
+	if j < 0 {
+		return strings.Replace(t, "{line}", "1", -1), ""
+	}
+	lineFmt = strings.Replace(t[j:], "%", "%%", -1)
+	return t[:j], strings.Replace(lineFmt, "{line}", "%d", 1)
+}
+
+func getMeta(ctx context.Context, client *http.Client, importPath string) (projectRoot, projectName, projectURL, repoRoot string, gosrc *goSource, err error) {
 	var resp *http.Response
 
+	ctx, cancel := withTimeout(ctx, timeouts.Meta)
+	defer cancel()
+	get := func(url string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		return client.Do(req)
+	}
+
 	uri := importPath
 	if !strings.Contains(uri, "/") {
 		// Add slash for root of domain.
@@ -61,15 +142,15 @@ func getMeta(client *http.Client, importPath string) (projectRoot, projectName,
 	uri = uri + "?go-get=1"
 
 	proto := "https://"
-	resp, err = client.Get(proto + uri)
+	resp, err = get(proto + uri)
 	if err != nil || resp.StatusCode != 200 {
 		if err == nil {
 			resp.Body.Close()
 		}
 		proto = "http://"
-		resp, err = client.Get(proto + uri)
+		resp, err = get(proto + uri)
 		if err != nil {
-			err = GetError{strings.SplitN(importPath, "/", 2)[0], err}
+			err = fetchError(strings.SplitN(importPath, "/", 2)[0], err)
 			return
 		}
 	}
@@ -78,7 +159,10 @@ func getMeta(client *http.Client, importPath string) (projectRoot, projectName,
 	d := xml.NewDecoder(resp.Body)
 	d.Strict = false
 
+	host := resp.Request.URL.Host
+	badMeta := false
 	err = ErrPackageNotFound
+loop:
 	for {
 		t, tokenErr := d.Token()
 		if tokenErr != nil {
@@ -87,25 +171,47 @@ func getMeta(client *http.Client, importPath string) (projectRoot, projectName,
 		switch t := t.(type) {
 		case xml.EndElement:
 			if strings.EqualFold(t.Name.Local, "head") {
-				return
+				break loop
 			}
 		case xml.StartElement:
 			if strings.EqualFold(t.Name.Local, "body") {
-				return
+				break loop
+			}
+			if !strings.EqualFold(t.Name.Local, "meta") {
+				continue
+			}
+			if attrValue(t.Attr, "name") == "go-source" {
+				f := strings.Fields(attrValue(t.Attr, "content"))
+				if len(f) == 4 && (importPath == f[0] || strings.HasPrefix(importPath, f[0]+"/")) {
+					gosrc = &goSource{home: f[1], dir: f[2], file: f[3]}
+					for _, t := range []*string{&gosrc.home, &gosrc.dir, &gosrc.file} {
+						if *t == "_" {
+							*t = ""
+						} else if !isWebURL(*t) {
+							// Ignore the tag and use the default project URL.
+							gosrc = nil
+							break
+						}
+					}
+				}
+				continue
 			}
-			if !strings.EqualFold(t.Name.Local, "meta") ||
-				attrValue(t.Attr, "name") != "go-import" {
+			if attrValue(t.Attr, "name") != "go-import" {
 				continue
 			}
 			f := strings.Fields(attrValue(t.Attr, "content"))
-			if len(f) != 3 ||
+			if len(f) == 0 ||
 				!strings.HasPrefix(importPath, f[0]) ||
 				!(len(importPath) == len(f[0]) || importPath[len(f[0])] == '/') {
 				continue
 			}
+			if len(f) != 3 {
+				badMeta = true
+				continue
+			}
 			if err == nil {
-				// More than one matching meta tag. Handle as not found.
-				err = ErrPackageNotFound
+				err = GetError{Host: host, Kind: ErrorAmbiguousImport,
+					err: fmt.Errorf("more than one go-import meta tag for %s", importPath)}
 				return
 			}
 			err = nil
@@ -115,34 +221,55 @@ func getMeta(client *http.Client, importPath string) (projectRoot, projectName,
 			projectURL = proto + projectRoot
 		}
 	}
+	if err == ErrPackageNotFound && badMeta {
+		err = GetError{Host: host, Kind: ErrorBadMeta,
+			err: fmt.Errorf("malformed go-import meta tag for %s", importPath)}
+	}
 	return
 }
 
 // getDynamic gets a document from a service that is not statically known.
-func getDynamic(client *http.Client, importPath string, etag string) (*Package, error) {
-	projectRoot, projectName, projectURL, repoRoot, err := getMeta(client, importPath)
+func getDynamic(ctx context.Context, client *http.Client, importPath string, etag string) (*Package, error) {
+	projectRoot, projectName, projectURL, repoRoot, gosrc, err := getMeta(ctx, client, importPath)
 	if err != nil {
 		return nil, err
 	}
+	if gosrc != nil && gosrc.home != "" {
+		projectURL = gosrc.home
+	}
 
 	if projectRoot != importPath {
 		var projectRoot2 string
-		projectRoot2, projectName, projectURL, _, err = getMeta(client, projectRoot)
+		projectRoot2, projectName, projectURL, _, _, err = getMeta(ctx, client, projectRoot)
 		if err != nil {
 			return nil, err
 		}
 		if projectRoot2 != projectRoot {
 			return nil, ErrPackageNotFound
 		}
+		if gosrc != nil && gosrc.home != "" {
+			projectURL = gosrc.home
+		}
 	}
 
+	return getRepoDoc(ctx, client, importPath, repoRoot, projectRoot, projectName, projectURL, gosrc, etag)
+}
+
+// getRepoDoc gets a document for an import path in a project hosted in the
+// repository at repoRoot. When the repository is not on a statically known
+// service, the go-source templates in gosrc are used to link to the project's
+// source browser. The gosrc argument is nil if there is no go-source meta tag.
+func getRepoDoc(ctx context.Context, client *http.Client, importPath, repoRoot, projectRoot, projectName, projectURL string, gosrc *goSource, etag string) (*Package, error) {
 	i := strings.Index(repoRoot, "://")
 	if i < 0 {
 		return nil, ErrPackageNotFound
 	}
 	importPath2 := repoRoot[i+len("://"):] + importPath[len(projectRoot):]
+	if IsBlocked(importPath2) {
+		return nil, ErrBlocked
+	}
 
-	pdoc, err := getStatic(client, importPath2, etag)
+	pdoc, err := getStatic(ctx, client, importPath2, etag)
 
 	if err == nil {
 		pdoc.ImportPath = importPath
@@ -153,7 +280,7 @@ func getDynamic(client *http.Client, importPath string, etag string) (*Package,
 	}
 
 	if err == errNoMatch {
-		return getProxyDoc(client, importPath, projectRoot, projectName, projectURL, etag)
+		return getProxyDoc(ctx, client, importPath, projectRoot, projectName, projectURL, gosrc, etag)
 	}
 
 	return nil, err
@@ -163,7 +290,7 @@ var errNoMatch = errors.New("no match")
 
 // getStatic gets a document from a statically known service. getStatic returns
 // errNoMatch if the import path is not recognized.
-func getStatic(client *http.Client, importPath string, etag string) (*Package, error) {
+func getStatic(ctx context.Context, client *http.Client, importPath string, etag string) (*Package, error) {
 	for _, s := range services {
 		if !strings.HasPrefix(importPath, s.prefix) {
 			continue
@@ -173,12 +300,20 @@ func getStatic(client *http.Client, importPath string, etag string) (*Package, e
 			// Import path is bad if prefix matches and regexp does not.
 			return nil, ErrPackageNotFound
 		}
-		return s.getDoc(client, m, etag)
+		return s.getDoc(ctx, client, m, etag)
 	}
 	return nil, errNoMatch
 }
 
-func Get(client *http.Client, importPath string, etag string) (pdoc *Package, err error) {
+// Get gets the documentation for the package with importPath. If etag
+// matches the version of the stored documentation, ErrPackageNotModified is
+// returned.
+func Get(client *http.Client, importPath string, etag string) (*Package, error) {
+	return GetContext(context.Background(), client, importPath, etag)
+}
+
+// GetContext is like Get, but the requests are stopped when ctx is done.
+func GetContext(ctx context.Context, client *http.Client, importPath string, etag string) (pdoc *Package, err error) {
 
 	const versionPrefix = PackageVersion + "-"
 
@@ -188,20 +323,33 @@ func Get(client *http.Client, importPath string, etag string) (pdoc *Package, er
 		etag = ""
 	}
 
+	if fetchPolicy != nil {
+		client = fetchPolicy.Client(client)
+	}
+	client, creds := withCredentials(client, importPath)
+
 	switch {
 	case StandardPackages[importPath]:
-		pdoc, err = getStandardDoc(client, importPath, etag)
+		pdoc, err = getStandardDoc(ctx, client, importPath, etag)
 	case !ValidRemotePath(importPath):
 		return nil, ErrPackageNotFound
+	case IsBlocked(importPath):
+		return nil, ErrBlocked
 	default:
-		pdoc, err = getStatic(client, importPath, etag)
+		pdoc, err = getVanity(ctx, client, importPath, etag)
+		if err == errNoMatch {
+			pdoc, err = getStatic(ctx, client, importPath, etag)
+		}
 		if err == errNoMatch {
-			pdoc, err = getDynamic(client, importPath, etag)
+			pdoc, err = getDynamic(ctx, client, importPath, etag)
 		}
 	}
 
 	if err == nil {
 		pdoc.Etag = versionPrefix + pdoc.Etag
+		checkModulePath(pdoc)
+		setCanonicalImportPath(pdoc)
+		pdoc.Private = creds.isPrivate()
 	}
 
 	return pdoc, err
@@ -15,7 +15,20 @@
 package doc
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var goodImportPaths = []string{
@@ -45,3 +58,1263 @@ func TestValidRemotePath(t *testing.T) {
 		}
 	}
 }
+
+const annotationTestSource = `package p
+
+import "fmt"
+
+type Foo struct{ X int }
+
+const C = 1
+
+func (f *Foo) Bar() string {
+	return fmt.Sprint(Foo{X: C}, f.X)
+}
+`
+
+var sourceAnnotationTests = []struct {
+	text string
+	a    TypeAnnotation
+}{
+	{"Foo", TypeAnnotation{Name: "Foo"}},
+	{"C", TypeAnnotation{Name: "C"}},
+	{"Foo", TypeAnnotation{Name: "Foo"}},
+	{"Bar", TypeAnnotation{Name: "Foo.Bar"}},
+	{"Sprint", TypeAnnotation{ImportPath: "fmt", Name: "Sprint"}},
+	{"Foo", TypeAnnotation{Name: "Foo"}},
+	{"C", TypeAnnotation{Name: "C"}},
+}
+
+func TestSourceAnnotations(t *testing.T) {
+	pdoc, err := buildDoc("example.com/p", "", "", "", "", "#L%d",
+		[]*source{{name: "p.go", data: []byte(annotationTestSource)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdoc.Files) != 1 {
+		t.Fatalf("len(pdoc.Files) = %d, want 1", len(pdoc.Files))
+	}
+	annotations := pdoc.Files[0].Source.Annotations
+	if len(annotations) != len(sourceAnnotationTests) {
+		t.Fatalf("len(annotations) = %d, want %d", len(annotations), len(sourceAnnotationTests))
+	}
+	for i, tt := range sourceAnnotationTests {
+		a := annotations[i]
+		text := annotationTestSource[a.Pos:a.End]
+		if text != tt.text || a.ImportPath != tt.a.ImportPath || a.Name != tt.a.Name {
+			t.Errorf("annotation %d = %q %+v, want %q %+v", i, text, a, tt.text, tt.a)
+		}
+	}
+}
+
+const verifyTestSource = `package p
+
+func Foo() int { return 1 }
+`
+
+const verifyTestExamples = `package p_test
+
+import "example.com/p"
+
+func ExampleFoo() {
+	p.Foo()
+}
+
+func Example() {
+	p.Bar()
+}
+`
+
+func TestVerifyExamples(t *testing.T) {
+	pdoc, err := buildDoc("example.com/p", "", "", "", "", "#L%d", []*source{
+		{name: "p.go", data: []byte(verifyTestSource)},
+		{name: "p_test.go", data: []byte(verifyTestExamples)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	getPackage := func(importPath string) (*Package, error) { return nil, ErrPackageNotFound }
+	if n := VerifyExamples(pdoc, getPackage); n != 1 {
+		t.Errorf("VerifyExamples() = %d, want 1", n)
+	}
+	if len(pdoc.Examples) != 1 || len(pdoc.Examples[0].Errors) == 0 {
+		t.Errorf("package example not reported as broken: %+v", pdoc.Examples)
+	}
+	if len(pdoc.Funcs) != 1 || len(pdoc.Funcs[0].Examples) != 1 || len(pdoc.Funcs[0].Examples[0].Errors) != 0 {
+		t.Errorf("func example reported as broken: %+v", pdoc.Funcs)
+	}
+
+	// Examples that import a package without stored source are not verified.
+	pdoc, err = buildDoc("example.com/p", "", "", "", "", "#L%d", []*source{
+		{name: "p.go", data: []byte(verifyTestSource)},
+		{name: "p_test.go", data: []byte(verifyTestMissingExamples)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := VerifyExamples(pdoc, getPackage); n != 0 {
+		t.Errorf("VerifyExamples() with missing import = %d, want 0: %+v %+v", n, pdoc.Examples, pdoc.Funcs)
+	}
+}
+
+const verifyTestMissingExamples = `package p_test
+
+import (
+	"example.com/missing"
+	"example.com/p"
+)
+
+func ExampleFoo() {
+	p.Foo()
+	missing.X()
+}
+
+func Example() {
+	missing.Y(p.Foo())
+}
+`
+
+const notesTestSource = `// Package p is a test.
+package p
+
+// BUG(gary): Foo is slow.
+
+// TODO(gary): Make Foo fast.
+
+// Foo does nothing.
+//
+// Deprecated: Use Bar.
+func Foo() {}
+
+// Bar does nothing quickly.
+func Bar() {}
+`
+
+func TestNotesAndDeprecated(t *testing.T) {
+	pdoc, err := buildDoc("example.com/p", "", "", "", "", "#L%d",
+		[]*source{{name: "p.go", data: []byte(notesTestSource)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, marker := range []string{"BUG", "TODO"} {
+		notes := pdoc.Notes[marker]
+		if len(notes) != 1 || notes[0].UID != "gary" {
+			t.Errorf("pdoc.Notes[%q] = %+v, want one note from gary", marker, notes)
+		}
+	}
+	for _, f := range pdoc.Funcs {
+		want := ""
+		if f.Name == "Foo" {
+			want = "Deprecated: Use Bar."
+		}
+		if f.Deprecated != want {
+			t.Errorf("%s.Deprecated = %q, want %q", f.Name, f.Deprecated, want)
+		}
+	}
+}
+
+const analyzeTestSource = `package p
+
+import (
+	"os"
+	strings "example.com/strings"
+)
+
+func Foo() error { return os.NewError("foo") }
+
+// Bar does nothing.
+func Bar(len int) string { return strings.X }
+
+var copy = 1
+`
+
+var analyzeTests = []struct {
+	analyzer string
+	line     int
+	severity Severity
+}{
+	{"shadow", 5, SeverityWarning},
+	{"doccomment", 8, SeverityInfo},
+	{"deprecated", 8, SeverityError},
+	{"shadow", 13, SeverityWarning},
+}
+
+func TestAnalyze(t *testing.T) {
+	pdoc, err := buildDoc("example.com/p", "", "", "", "", "#L%d", []*source{
+		{name: "p.go", data: []byte(analyzeTestSource)},
+		{name: "q.go", data: []byte("package p\n\nimport \"strings\"\n\n// Q is strings.ToUpper.\nvar Q = strings.ToUpper\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdoc.Diagnostics) != len(analyzeTests) {
+		t.Fatalf("got diagnostics %v, want %d", pdoc.Diagnostics, len(analyzeTests))
+	}
+	for i, tt := range analyzeTests {
+		d := pdoc.Diagnostics[i]
+		if d.File != "p.go" || d.Analyzer != tt.analyzer || d.Line != tt.line || d.Severity != tt.severity {
+			t.Errorf("diagnostic %d = %v, want %s at line %d with severity %s", i, &d, tt.analyzer, tt.line, tt.severity)
+		}
+	}
+}
+
+func TestDocCommentLimit(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("// Package p is a test.\npackage p\n\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&buf, "func F%d() {}\n", i)
+	}
+	pdoc, err := buildDoc("example.com/p", "", "", "", "", "#L%d", []*source{{name: "p.go", data: buf.Bytes()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdoc.Diagnostics) != maxDocCommentDiagnostics+1 {
+		t.Fatalf("got %d diagnostics, want %d", len(pdoc.Diagnostics), maxDocCommentDiagnostics+1)
+	}
+	last := pdoc.Diagnostics[maxDocCommentDiagnostics]
+	if want := fmt.Sprintf("%d more exported declarations should have a comment", 100-maxDocCommentDiagnostics); last.Message != want {
+		t.Errorf("last diagnostic = %q, want %q", last.Message, want)
+	}
+}
+
+const qualityTestSource = `// Package p is a test.
+package p
+
+// Foo does nothing.
+func Foo() {}
+
+// Does nothing.
+func Bar() {}
+
+func Baz() {}
+
+// A T is a type.
+type T int
+
+// String returns the value as a string.
+func (t T) String() string { return "" }
+
+// Constants.
+const (
+	A = 1
+	B = 2
+)
+`
+
+func TestQuality(t *testing.T) {
+	pdoc, err := buildDoc("example.com/p", "", "", "", "", "#L%d",
+		[]*source{{name: "p.go", data: []byte(qualityTestSource)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := pdoc.Quality
+	if q == nil {
+		t.Fatal("pdoc.Quality is nil")
+	}
+	if q.Exported != 7 || q.Documented != 6 || !q.PackageDoc || q.Types != 1 {
+		t.Errorf("quality = %+v, want 7 exported, 6 documented, package doc and 1 type", q)
+	}
+	if len(q.BadComments) != 1 || q.BadComments[0] != "Bar" {
+		t.Errorf("BadComments = %v, want [Bar]", q.BadComments)
+	}
+	// 60*6/7 + 20*5/6 + 10 + 0 = 78.1
+	if q.Score != 78 {
+		t.Errorf("Score = %d, want 78", q.Score)
+	}
+}
+
+const apiVersionTestSource = `package p
+
+import (
+	"bytes"
+	"strings"
+)
+
+// X is a test.
+var X = strings.ToUpper("x") + string(bytes.Clone(nil))
+`
+
+const apiMemberTestSource = `package p
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// F is a test.
+func F(r *http.Request) int {
+	var b bytes.Buffer
+	b.Grow(len(r.Method))
+	return b.Available() + len(bytes.NewBuffer(nil).AvailableBuffer())
+}
+
+// G is a test.
+func G(r *http.Request) string {
+	req := r
+	return req.Pattern
+}
+`
+
+func TestAPIVersions(t *testing.T) {
+	if err := LoadAPIHistory("../api"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { apiVersions, apiTypes, apiPackages = nil, nil, nil }()
+
+	var tests = []struct {
+		src         string
+		version     string
+		diagnostics int
+	}{
+		{apiVersionTestSource, "go1.20", 1},
+		// Methods and fields: Available and AvailableBuffer are in
+		// go1.21 and Pattern is in go1.23.
+		{apiMemberTestSource, "go1.23", 3},
+	}
+	for _, tt := range tests {
+		pdoc, err := buildDoc("example.com/p", "", "", "", "", "#L%d", []*source{
+			{name: "p.go", data: []byte(tt.src)},
+			{name: "go.mod", data: []byte("module example.com/p\n\ngo 1.18\n")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pdoc.MinGoVersion != tt.version {
+			t.Errorf("MinGoVersion = %q, want %s", pdoc.MinGoVersion, tt.version)
+		}
+		if len(pdoc.Diagnostics) != tt.diagnostics {
+			t.Errorf("Diagnostics = %v, want %d apiversion diagnostics", pdoc.Diagnostics, tt.diagnostics)
+		}
+		for _, d := range pdoc.Diagnostics {
+			if d.Analyzer != "apiversion" {
+				t.Errorf("Diagnostic %v is not from apiversion", d)
+			}
+		}
+	}
+}
+
+const importsTestSource = `package p
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"local"
+	"net/netip"
+	"slices"
+	"time/tzdata"
+)
+`
+
+func TestImports(t *testing.T) {
+	if err := LoadAPIHistory("../api"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { apiVersions, apiTypes, apiPackages = nil, nil, nil }()
+
+	// The duplicate diagnostic for the second file is dropped. The first
+	// file in sorted order is reported on every run.
+	for i := 0; i < 10; i++ {
+		pdoc, err := buildDoc("example.com/p", "", "", "", "", "#L%d", []*source{
+			{name: "c.go", data: []byte(importsTestSource)},
+			{name: "b.go", data: []byte(importsTestSource)},
+			{name: "a.go", data: []byte(importsTestSource)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var diagnostics []Diagnostic
+		for _, d := range pdoc.Diagnostics {
+			if d.Analyzer == "imports" {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+		if len(diagnostics) != 1 || diagnostics[0].File != "a.go" || diagnostics[0].Line != 7 {
+			t.Fatalf("diagnostics = %v, want one imports diagnostic for \"local\" at a.go:7", diagnostics)
+		}
+	}
+}
+
+const modFileTestSource = `module example.com/m
+
+go 1.21
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.2.0 // indirect
+)
+
+replace example.com/a => ../a
+
+retract [v1.0.0, v1.0.5]
+`
+
+func TestModule(t *testing.T) {
+	pdoc, err := buildDoc("example.com/m/sub", "", "", "", "", "#L%d", []*source{
+		{name: "p.go", data: []byte("// Package p is a test.\npackage p\n")},
+		{name: "../go.mod", data: []byte(modFileTestSource)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := pdoc.Module
+	if m == nil {
+		t.Fatal("Module = nil")
+	}
+	if m.Path != "example.com/m" || m.GoVersion != "1.21" || m.Dir != "sub" {
+		t.Errorf("Module = %+v", m)
+	}
+	if len(m.Require) != 2 || m.Require[0].Indirect || !m.Require[1].Indirect {
+		t.Errorf("Require = %+v", m.Require)
+	}
+	if len(m.Replace) != 1 || m.Replace[0].New != "../a" {
+		t.Errorf("Replace = %+v", m.Replace)
+	}
+	if len(m.Retract) != 1 || m.Retract[0] != "[v1.0.0, v1.0.5]" {
+		t.Errorf("Retract = %v", m.Retract)
+	}
+	if len(pdoc.Diagnostics) != 0 {
+		t.Errorf("Diagnostics = %v, want none", pdoc.Diagnostics)
+	}
+
+	pdoc.ImportPath = "example.com/other/sub"
+	checkModulePath(pdoc)
+	if len(pdoc.Diagnostics) != 1 || pdoc.Diagnostics[0].Analyzer != "gomod" {
+		t.Errorf("Diagnostics = %v, want one gomod diagnostic", pdoc.Diagnostics)
+	}
+}
+
+func TestFetchGoMod(t *testing.T) {
+	status := map[string]int{"/a/b/go.mod": 404, "/a/go.mod": 200}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s := status[r.URL.Path]; s != 200 {
+			http.Error(w, "error", s)
+			return
+		}
+		w.Write([]byte("module example.com/m/a\n"))
+	}))
+	defer ts.Close()
+	urls := func(p string) (string, string) { return ts.URL + "/" + p, "" }
+
+	src, err := fetchGoMod(context.Background(), http.DefaultClient, "a/b", nil, urls)
+	if err != nil || src == nil || src.name != "../go.mod" {
+		t.Errorf("fetchGoMod returned %+v, %v, want ../go.mod", src, err)
+	}
+
+	// A server error is not taken to mean that the directory does not have a
+	// go.mod file.
+	status["/a/b/go.mod"] = 503
+	if src, err := fetchGoMod(context.Background(), http.DefaultClient, "a/b", nil, urls); err == nil {
+		t.Errorf("fetchGoMod with server error returned %+v, want error", src)
+	}
+	// The candidates are fetched concurrently. The handler holds each
+	// request until all three are received.
+	var mu sync.Mutex
+	arrived := make(chan struct{})
+	n := 0
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n++
+		if n == 3 {
+			close(arrived)
+		}
+		mu.Unlock()
+		select {
+		case <-arrived:
+		case <-time.After(5 * time.Second):
+			http.Error(w, "requests are sequential", 503)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts2.Close()
+	urls = func(p string) (string, string) { return ts2.URL + "/" + p, "" }
+	if src, err := fetchGoMod(context.Background(), http.DefaultClient, "a/b", nil, urls); src != nil || err != nil {
+		t.Errorf("fetchGoMod returned %+v, %v, want nil, nil", src, err)
+	}
+}
+
+func TestCanonicalImportPath(t *testing.T) {
+	pdoc, err := buildDoc("github.com/fork/p", "github.com/fork/p", "", "", "", "#L%d", []*source{
+		{name: "a.go", data: []byte("// Package p is a test.\npackage p // import \"example.com/p\"\n")},
+		{name: "b.go", data: []byte("package p // import \"example.com/q\"\n")},
+		{name: "c.go", data: []byte("package p\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdoc.ImportComment != "example.com/p" {
+		t.Errorf("ImportComment = %q, want example.com/p", pdoc.ImportComment)
+	}
+	if len(pdoc.Diagnostics) != 1 || pdoc.Diagnostics[0].Analyzer != "importcomment" {
+		t.Errorf("Diagnostics = %v, want one importcomment diagnostic", pdoc.Diagnostics)
+	}
+
+	// The import comment does not depend on the registered analyzers.
+	saved := analyzers
+	analyzers = nil
+	pdoc, err = buildDoc("github.com/fork/p", "github.com/fork/p", "", "", "", "#L%d", []*source{
+		{name: "b.go", data: []byte("package p // import \"example.com/p\"\n")},
+	})
+	analyzers = saved
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdoc.ImportComment != "example.com/p" || len(pdoc.Diagnostics) != 0 {
+		t.Errorf("without analyzers ImportComment = %q, Diagnostics = %v", pdoc.ImportComment, pdoc.Diagnostics)
+	}
+	setCanonicalImportPath(pdoc)
+	if pdoc.CanonicalImportPath != "example.com/p" {
+		t.Errorf("CanonicalImportPath = %q, want example.com/p", pdoc.CanonicalImportPath)
+	}
+
+	pdoc.Module = &Module{Path: "github.com/fork/p"}
+	pdoc.CanonicalImportPath = ""
+	setCanonicalImportPath(pdoc)
+	if pdoc.CanonicalImportPath != "" {
+		t.Errorf("CanonicalImportPath = %q, want module path to take precedence", pdoc.CanonicalImportPath)
+	}
+
+	for _, tt := range []struct {
+		importPath, modulePath, dir string
+		matches                     bool
+	}{
+		{"github.com/x/y", "github.com/x/y/v2", "", true},
+		{"github.com/x/y/sub", "github.com/x/y/v3", "sub", true},
+		{"github.com/x/y/v2", "github.com/x/y/v2", "", true},
+		{"gopkg.in/yaml.v2", "gopkg.in/yaml.v2", "", true},
+		{"gopkg.in/yaml.v3/sub", "gopkg.in/yaml.v2", "sub", true},
+		{"gopkg.in/user/p.v1", "gopkg.in/user/p.v2", "", true},
+		{"github.com/fork/y", "github.com/x/y/v2", "", false},
+		{"github.com/x/y", "github.com/x/y/v1", "", false},
+		{"github.com/x/y", "github.com/x/y/v02", "", false},
+	} {
+		pdoc := &Package{
+			ImportPath:  tt.importPath,
+			ProjectRoot: tt.importPath,
+			Module:      &Module{Path: tt.modulePath, Dir: tt.dir},
+		}
+		setCanonicalImportPath(pdoc)
+		checkModulePath(pdoc)
+		if (pdoc.CanonicalImportPath == "") != tt.matches || (len(pdoc.Diagnostics) == 0) != tt.matches {
+			t.Errorf("import path %s, module %s: CanonicalImportPath = %q, Diagnostics = %v, want match %v",
+				tt.importPath, tt.modulePath, pdoc.CanonicalImportPath, pdoc.Diagnostics, tt.matches)
+		}
+	}
+}
+
+func TestVanityRules(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/raw/tools/sub/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/raw/tools/sub/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<a href="a.go">a.go</a> <a href="/raw/tools/sub/a_test.go">a_test.go</a> <a href="../">..</a>`))
+	})
+	aSource := "// Package sub is a test.\npackage sub\n\n// F does nothing.\nfunc F() {}\n"
+	mux.HandleFunc("/raw/tools/sub/a.go", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(aSource))
+	})
+	mux.HandleFunc("/raw/tools/sub/a_test.go", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("package sub\n"))
+	})
+	mux.HandleFunc("/raw/tools/go.mod", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("module go.example.com/tools\n"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	rule := &VanityRule{
+		Pattern:   `go\.example\.com/(?P<repo>[a-z]+)`,
+		VCS:       "git",
+		Repo:      ts.URL + "/{repo}",
+		BrowseURL: ts.URL + "/browse/{repo}/{path}",
+		RawURL:    ts.URL + "/raw/{repo}/{path}",
+	}
+	if err := rule.compile(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { vanityRules = nil }()
+	vanityRules = []*VanityRule{rule}
+
+	if _, err := getVanity(context.Background(), http.DefaultClient, "example.com/tools", ""); err != errNoMatch {
+		t.Errorf("getVanity(example.com/tools) returned %v, want errNoMatch", err)
+	}
+	if _, err := getVanity(context.Background(), http.DefaultClient, "go.example.comx/tools", ""); err != errNoMatch {
+		t.Errorf("getVanity(go.example.comx/tools) returned %v, want errNoMatch", err)
+	}
+
+	pdoc, err := getVanity(context.Background(), http.DefaultClient, "go.example.com/tools/sub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdoc.Name != "sub" || pdoc.ProjectRoot != "go.example.com/tools" || len(pdoc.Funcs) != 1 {
+		t.Errorf("got name %q, root %q, %d funcs", pdoc.Name, pdoc.ProjectRoot, len(pdoc.Funcs))
+	}
+	if len(pdoc.Files) != 1 || pdoc.Files[0].URL != ts.URL+"/browse/tools/sub/a.go" {
+		t.Errorf("Files = %+v", pdoc.Files)
+	}
+	if pdoc.Module == nil || pdoc.Module.Path != "go.example.com/tools" || pdoc.Module.Dir != "sub" {
+		t.Errorf("Module = %+v", pdoc.Module)
+	}
+
+	if _, err := getVanity(context.Background(), http.DefaultClient, "go.example.com/tools/sub", pdoc.Etag); err != ErrPackageNotModified {
+		t.Errorf("getVanity with etag returned %v, want ErrPackageNotModified", err)
+	}
+	// A change to a file that does not change the listing is found.
+	aSource += "\n// G does nothing.\nfunc G() {}\n"
+	pdoc, err = getVanity(context.Background(), http.DefaultClient, "go.example.com/tools/sub", pdoc.Etag)
+	if err != nil {
+		t.Fatalf("getVanity after change returned %v", err)
+	}
+	if len(pdoc.Funcs) != 2 {
+		t.Errorf("got %d funcs after change, want 2", len(pdoc.Funcs))
+	}
+}
+
+var goSourceTests = []struct {
+	file, dir, name string
+	browseURL       string
+	lineFmt         string
+}{
+	{"https://github.com/u/r/blob/master{/dir}/{file}#L{line}", "sub", "a.go", "https://github.com/u/r/blob/master/sub/a.go", "#L%d"},
+	{"https://github.com/u/r/blob/master{/dir}/{file}#L{line}", "", "a.go", "https://github.com/u/r/blob/master/a.go", "#L%d"},
+	{"http://example.com/src?f={dir}/{file}&line={line}", "x", "b.go", "http://example.com/src?f=x/b.go", "&line=%d"},
+	{"http://example.com/src/{dir}/{file}", "x", "b.go", "http://example.com/src/x/b.go", ""},
+}
+
+func TestGoSource(t *testing.T) {
+	for _, tt := range goSourceTests {
+		s := &goSource{file: tt.file}
+		browseURL, lineFmt := s.fileURL(tt.dir, tt.name)
+		if browseURL != tt.browseURL || lineFmt != tt.lineFmt {
+			t.Errorf("fileURL(%q, %q) with %q = %q, %q, want %q, %q", tt.dir, tt.name, tt.file, browseURL, lineFmt, tt.browseURL, tt.lineFmt)
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head>
+<meta name="go-import" content="` + r.Host + `/p git https://github.com/u/p">
+<meta name="go-source" content="` + r.Host + `/p https://github.com/u/p _ https://github.com/u/p/blob/master{/dir}/{file}#L{line}">
+</head></html>`))
+	}))
+	defer ts.Close()
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	projectRoot, _, _, _, gosrc, err := getMeta(context.Background(), http.DefaultClient, host+"/p/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if projectRoot != host+"/p" {
+		t.Errorf("projectRoot = %q, want %q", projectRoot, host+"/p")
+	}
+	if gosrc == nil || gosrc.home != "https://github.com/u/p" || gosrc.dir != "" {
+		t.Errorf("gosrc = %+v", gosrc)
+	}
+
+	// Templates that are not http or https URLs are ignored.
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head>
+<meta name="go-import" content="` + r.Host + `/p git https://github.com/u/p">
+<meta name="go-source" content="` + r.Host + `/p javascript:alert(1) _ https://github.com/u/p/blob/master{/dir}/{file}#L{line}">
+</head></html>`))
+	}))
+	defer ts.Close()
+
+	host = strings.TrimPrefix(ts.URL, "http://")
+	_, _, projectURL, _, gosrc, err := getMeta(context.Background(), http.DefaultClient, host+"/p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gosrc != nil {
+		t.Errorf("gosrc = %+v, want nil for javascript: template", gosrc)
+	}
+	if projectURL != "http://"+host+"/p" {
+		t.Errorf("projectURL = %q, want %q", projectURL, "http://"+host+"/p")
+	}
+}
+
+func TestGitlab(t *testing.T) {
+	probes := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/projects/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", 401)
+			return
+		}
+		p := r.URL.EscapedPath()
+		if !strings.Contains(strings.TrimPrefix(p, "/api/v4/projects/"), "/") {
+			probes++
+		}
+		switch {
+		case strings.HasSuffix(p, "/projects/u%2Fr"), strings.HasSuffix(p, "/projects/g%2Fs%2Fp"):
+			w.Write([]byte(`{"default_branch": "main"}`))
+		case strings.HasSuffix(p, "/repository/branches/main"):
+			w.Write([]byte(`{"commit": {"id": "abc123"}}`))
+		case strings.HasSuffix(p, "/repository/tree"):
+			if r.FormValue("ref") != "abc123" || r.FormValue("path") != "sub" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`[{"name": "a.go", "path": "sub/a.go", "type": "blob"}, {"name": "x", "path": "sub/x", "type": "tree"}]`))
+		case strings.HasSuffix(p, "/repository/files/sub%2Fa.go/raw"):
+			w.Write([]byte("// Package sub is a test.\npackage sub\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	saved := services
+	defer func() { services = saved }()
+	services = append([]*service(nil), services...)
+	AddGitlabServer("gitlab.example.com", ts.URL, "secret")
+
+	pdoc, err := getStatic(context.Background(), http.DefaultClient, "gitlab.example.com/u/r/sub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdoc.Name != "sub" || pdoc.Etag != "abc123" || pdoc.ProjectRoot != "gitlab.example.com/u/r" {
+		t.Errorf("got name %q, etag %q, root %q", pdoc.Name, pdoc.Etag, pdoc.ProjectRoot)
+	}
+	if len(pdoc.Files) != 1 || pdoc.Files[0].URL != ts.URL+"/u/r/-/blob/abc123/sub/a.go" {
+		t.Errorf("Files = %+v", pdoc.Files)
+	}
+
+	if _, err := getStatic(context.Background(), http.DefaultClient, "gitlab.example.com/u/r/sub", "abc123"); err != ErrPackageNotModified {
+		t.Errorf("getStatic with saved etag returned %v, want ErrPackageNotModified", err)
+	}
+
+	// The project is in a subgroup. The project path is probed once and then
+	// found in the cache.
+	for i, want := range []int{2, 1} {
+		probes = 0
+		pdoc, err = getStatic(context.Background(), http.DefaultClient, "gitlab.example.com/g/s/p/sub", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if probes != want {
+			t.Errorf("fetch %d: got %d project requests, want %d", i, probes, want)
+		}
+	}
+	if pdoc.ProjectRoot != "gitlab.example.com/g/s/p" || pdoc.ProjectName != "p" {
+		t.Errorf("got root %q, project name %q", pdoc.ProjectRoot, pdoc.ProjectName)
+	}
+	if len(pdoc.Files) != 1 || pdoc.Files[0].URL != ts.URL+"/g/s/p/-/blob/abc123/sub/a.go" {
+		t.Errorf("Files = %+v", pdoc.Files)
+	}
+
+	if _, err := getStatic(context.Background(), http.DefaultClient, "gitlab.example.com/g/s", ""); err != ErrPackageNotFound {
+		t.Errorf("getStatic for group returned %v, want ErrPackageNotFound", err)
+	}
+}
+
+func TestGithubEnterprise(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/u/r/git/refs", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"ref": "refs/heads/master", "object": {"sha": "abc123"}}]`))
+	})
+	mux.HandleFunc("/api/v3/repos/u/r/git/trees/master", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tree": [
+			{"path": "go.mod", "type": "blob", "url": "http://` + r.Host + `/api/v3/blobs/mod"},
+			{"path": "sub/a.go", "type": "blob", "url": "http://` + r.Host + `/api/v3/blobs/a"}]}`))
+	})
+	mux.HandleFunc("/api/v3/blobs/a", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" || r.Header.Get("Accept") != "application/vnd.github-blob.raw" {
+			http.Error(w, "bad request", 400)
+			return
+		}
+		w.Write([]byte("// Package sub is a test.\npackage sub\n"))
+	})
+	mux.HandleFunc("/api/v3/blobs/mod", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("module ghe.example.com/u/r\n"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	saved := services
+	defer func() { services = saved }()
+	services = append([]*service(nil), services...)
+	AddGithubServer("ghe.example.com", ts.URL+"/api/v3", ts.URL, "secret")
+
+	pdoc, err := getStatic(context.Background(), http.DefaultClient, "ghe.example.com/u/r/sub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdoc.Name != "sub" || pdoc.Etag != "abc123" || pdoc.ProjectURL != ts.URL+"/u/r/" {
+		t.Errorf("got name %q, etag %q, project URL %q", pdoc.Name, pdoc.Etag, pdoc.ProjectURL)
+	}
+	if len(pdoc.Files) != 1 || pdoc.Files[0].URL != ts.URL+"/u/r/blob/master/sub/a.go" {
+		t.Errorf("Files = %+v", pdoc.Files)
+	}
+	if pdoc.Module == nil || pdoc.Module.File != "../go.mod" {
+		t.Errorf("Module = %+v", pdoc.Module)
+	}
+}
+
+func TestGitea(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/repos/u/r", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"default_branch": "main"}`))
+	})
+	mux.HandleFunc("/api/v1/repos/u/r/branches/main", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"commit": {"id": "abc123"}}`))
+	})
+	mux.HandleFunc("/api/v1/repos/u/r/git/trees/abc123", func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("page") {
+		case "1":
+			w.Write([]byte(`{"tree": [{"path": "sub", "type": "tree"}], "truncated": true}`))
+		case "2":
+			w.Write([]byte(`{"tree": [{"path": "sub/a.go", "type": "blob"}, {"path": "sub/a_test.go", "type": "blob"}, {"path": "top/x#y/b.go", "type": "blob"}, {"path": "top/x#y/b_test.go", "type": "blob"}], "truncated": false}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	mux.HandleFunc("/api/v1/repos/u/r/raw/sub/", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("ref") != "abc123" || r.Header.Get("Authorization") != "token secret" {
+			http.Error(w, "bad request", 400)
+			return
+		}
+		w.Write([]byte("// Package sub is a test.\npackage sub\n"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	saved := services
+	defer func() { services = saved }()
+	services = append([]*service(nil), services...)
+	AddGiteaServer("git.example.com", ts.URL, "secret")
+
+	pdoc, err := getStatic(context.Background(), http.DefaultClient, "git.example.com/u/r/sub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdoc.Name != "sub" || pdoc.Etag != "abc123" {
+		t.Errorf("got name %q, etag %q", pdoc.Name, pdoc.Etag)
+	}
+	if len(pdoc.Files) != 1 || pdoc.Files[0].URL != ts.URL+"/u/r/src/commit/abc123/sub/a.go" {
+		t.Errorf("Files = %+v", pdoc.Files)
+	}
+
+	if _, err := getStatic(context.Background(), http.DefaultClient, "git.example.com/u/r/missing", ""); err != ErrPackageNotFound {
+		t.Errorf("getStatic(missing) returned %v, want ErrPackageNotFound", err)
+	}
+
+	// A directory with only subpackages is not a package.
+	if _, err := getStatic(context.Background(), http.DefaultClient, "git.example.com/u/r/top", ""); err != ErrPackageNotFound {
+		t.Errorf("getStatic(top) returned %v, want ErrPackageNotFound", err)
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	if got, want := escapePath("a b/c#d/e?.go"), "a%20b/c%23d/e%3F.go"; got != want {
+		t.Errorf("escapePath = %q, want %q", got, want)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	defer SetCredentialsProvider(nil)
+	SetCredentialsProvider(CredentialsList{
+		{Prefix: "example.com/private", Host: host, Username: "user", Password: "pass", Private: true},
+		{Prefix: "example.com/private/x", Host: "other.example.com", Token: "t", Private: true},
+		{Prefix: "example.com/public", Host: host, Username: "user", Password: "pass"},
+	})
+
+	client, creds := withCredentials(http.DefaultClient, "example.com/private/p")
+	if _, err := httpGetBytes(context.Background(), client, ts.URL+"/file"); err != nil {
+		t.Errorf("httpGetBytes with credentials returned %v", err)
+	}
+	if !creds.isPrivate() {
+		t.Error("isPrivate() = false, want true")
+	}
+
+	// Credentials without the Private flag do not make the package private.
+	client, creds = withCredentials(http.DefaultClient, "example.com/public/p")
+	if _, err := httpGetBytes(context.Background(), client, ts.URL+"/file"); err != nil {
+		t.Errorf("httpGetBytes with public credentials returned %v", err)
+	}
+	if creds.isPrivate() {
+		t.Error("isPrivate() with public credentials = true, want false")
+	}
+
+	client, creds = withCredentials(http.DefaultClient, "example.com/other")
+	if _, err := httpGetBytes(context.Background(), client, ts.URL+"/file"); err != ErrPackageNotFound {
+		t.Errorf("httpGetBytes without credentials returned %v, want ErrPackageNotFound", err)
+	}
+	if creds.isPrivate() {
+		t.Error("isPrivate() = true, want false")
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	b, err := NewBlocklist([]string{"host:spam.example", "prefix:github.com/spammer", "regexp:^github\\.com/[^/]+/casino", "bitbucket.org/old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for importPath, want := range map[string]bool{
+		"spam.example/p":             true,
+		"www.spam.example/p":         true,
+		"notspam.example/p":          false,
+		"github.com/spammer":         true,
+		"github.com/spammer/r/p":     true,
+		"github.com/spammers/r":      false,
+		"github.com/user/casino-bot": true,
+		"github.com/user/repo":       false,
+		"bitbucket.org/old/repo":     true,
+	} {
+		if got := b.Blocked(importPath); got != want {
+			t.Errorf("Blocked(%q) = %v, want %v", importPath, got, want)
+		}
+	}
+
+	if _, err := NewBlocklist([]string{"regexp:("}); err == nil {
+		t.Error("NewBlocklist with bad regexp returned nil error")
+	}
+
+	defer SetBlocklist(nil)
+	SetBlocklist(b)
+	if _, err := Get(http.DefaultClient, "github.com/spammer/r", ""); err != ErrBlocked {
+		t.Errorf("Get returned %v, want ErrBlocked", err)
+	}
+}
+
+func TestFetchPolicy(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", 302)
+		case "/internal":
+			http.Redirect(w, r, "http://internal.example/", 302)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer ts.Close()
+
+	// Servers added by other tests are allowed by all policies.
+	defer func(h map[string]bool) { serverHosts = h }(serverHosts)
+	serverHosts = make(map[string]bool)
+
+	defer func() { lookupIP = net.LookupIP }()
+	lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "internal.example":
+			return []net.IP{net.ParseIP("10.1.2.3")}, nil
+		case "mixed.example":
+			return []net.IP{net.ParseIP("203.0.113.1"), net.ParseIP("169.254.169.254")}, nil
+		}
+		return []net.IP{net.ParseIP("203.0.113.1")}, nil
+	}
+
+	p, err := NewFetchPolicy(nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string]bool{
+		"127.0.0.1":        false,
+		"::1":              false,
+		"::ffff:127.0.0.1": false,
+		"169.254.169.254":  false,
+		"192.168.1.1":      false,
+		"internal.example": false,
+		"mixed.example":    false,
+		"8.8.8.8":          true,
+		"public.example":   true,
+	} {
+		if _, err := p.resolve(host); (err == nil) != want {
+			t.Errorf("resolve(%q) returned %v, want allowed = %v", host, err, want)
+		}
+	}
+
+	client := p.Client(&http.Client{Transport: p.Transport()})
+	if _, err := httpGetBytes(context.Background(), client, ts.URL); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("get of loopback server returned %v, want refused", err)
+	}
+
+	p, err = NewFetchPolicy(nil, []string{"127.0.0.0/8"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = p.Client(&http.Client{Transport: p.Transport()})
+	if _, err := httpGetBytes(context.Background(), client, ts.URL); err != nil {
+		t.Errorf("get of allowed server returned %v", err)
+	}
+	if _, err := httpGetBytes(context.Background(), client, ts.URL+"/internal"); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("redirect to internal host returned %v, want refused", err)
+	}
+	if _, err := httpGetBytes(context.Background(), client, ts.URL+"/loop"); err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("redirect loop returned %v, want stopped", err)
+	}
+
+	// A host that resolves to a public address for the check and to the
+	// loopback address when connecting is refused by the default transport.
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	lookups := 0
+	lookupIP = func(host string) ([]net.IP, error) {
+		lookups++
+		if lookups == 1 {
+			return []net.IP{net.ParseIP("203.0.113.1")}, nil
+		}
+		return []net.IP{net.ParseIP("127.0.0.1")}, nil
+	}
+	p, err = NewFetchPolicy(nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = p.Client(&http.Client{})
+	if _, err := httpGetBytes(context.Background(), client, "http://rebind.example:"+port+"/"); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("get of rebinding host returned %v, want refused", err)
+	}
+	if lookups < 2 {
+		t.Errorf("rebinding host resolved %d times, want 2", lookups)
+	}
+
+	if _, err := NewFetchPolicy(nil, []string{"10.0.0.0"}, 0); err == nil {
+		t.Error("NewFetchPolicy with bad network returned nil error")
+	}
+}
+
+func makeArchive(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	for _, name := range []string{"a.go", "zeros.txt", "big.go", "b.go"} {
+		p, ok := files[name]
+		if !ok {
+			continue
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(p))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readArchive returns the files read from the archive and the limit errors.
+func readArchive(t *testing.T, p []byte) ([]string, []string) {
+	ar, err := newArchiveReader(bytes.NewReader(p), "example.com/p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names, errs []string
+	for {
+		hdr, err := ar.next()
+		if err == io.EOF {
+			break
+		}
+		if isLimitError(err) {
+			errs = append(errs, err.(*LimitError).Limit)
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isDocFile(hdr.Name) {
+			continue
+		}
+		if _, err := ar.read(hdr.Name); isLimitError(err) {
+			errs = append(errs, err.(*LimitError).Limit)
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	return names, errs
+}
+
+func TestLimits(t *testing.T) {
+	defer func(l Limits) { limits = l }(limits)
+	SetLimits(Limits{MaxFileBytes: 100, MaxFiles: 1})
+
+	src := []byte("package p\n")
+	p := makeArchive(t, map[string][]byte{"a.go": src, "big.go": bytes.Repeat([]byte("//\n"), 100), "b.go": src})
+	names, errs := readArchive(t, p)
+	if strings.Join(names, ",") != "a.go" || strings.Join(errs, ",") != "file count" {
+		t.Errorf("read %v with errors %v, want [a.go] with file count error", names, errs)
+	}
+
+	SetLimits(Limits{MaxFiles: 10})
+	names, errs = readArchive(t, p)
+	if strings.Join(names, ",") != "a.go,b.go" || strings.Join(errs, ",") != "file size" {
+		t.Errorf("read %v with errors %v, want [a.go b.go] with file size error", names, errs)
+	}
+
+	p = makeArchive(t, map[string][]byte{"a.go": src, "zeros.txt": make([]byte, 10<<20), "b.go": src})
+	names, errs = readArchive(t, p)
+	if strings.Join(names, ",") != "a.go" || strings.Join(errs, ",") != "compression ratio" {
+		t.Errorf("read %v with errors %v, want [a.go] with compression ratio error", names, errs)
+	}
+
+	SetLimits(Limits{MaxArchiveBytes: 50})
+	ar, err := newArchiveReader(bytes.NewReader(p), "example.com/p")
+	if err == nil {
+		_, err = ar.next()
+	}
+	if e, ok := err.(*LimitError); !ok || e.Limit != "archive size" {
+		t.Errorf("reading archive returned %v, want archive size error", err)
+	}
+
+	pdoc, err := buildDoc("example.com/p", "", "", "", "", "#L%d", []*source{
+		{name: "a.go", data: src},
+		{name: "big.go", err: &LimitError{Limit: "file size", Name: "big.go", Max: 100}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdoc.Name != "p" || len(pdoc.Errors) != 1 || !strings.Contains(pdoc.Errors[0], "big.go") {
+		t.Errorf("got name %q and errors %v, want partial documentation", pdoc.Name, pdoc.Errors)
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			http.Error(w, "bad", 500)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+	files := []*source{
+		{name: "a.go", rawURL: ts.URL + "/a"},
+		{name: "bad.go", rawURL: ts.URL + "/bad"},
+		{name: "b.go", rawURL: ts.URL + "/b"},
+	}
+	if err := fetchFiles(context.Background(), http.DefaultClient, files, nil); err == nil {
+		t.Error("fetchFiles returned nil error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("fetchFiles took %v, want return on first error", d)
+	}
+
+	defer func(t Timeouts) { timeouts = t }(timeouts)
+	SetTimeouts(Timeouts{Meta: 100 * time.Millisecond})
+	start = time.Now()
+	if _, _, _, _, _, err := getMeta(context.Background(), http.DefaultClient, strings.TrimPrefix(ts.URL, "http://")); err == nil {
+		t.Error("getMeta returned nil error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("getMeta took %v, want meta timeout", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	if _, err := httpGetBytes(ctx, http.DefaultClient, ts.URL+"/a"); err == nil {
+		t.Error("httpGetBytes returned nil error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("httpGetBytes took %v, want return on cancel", d)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/limited":
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(429)
+		case "/quota":
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(403)
+		case "/private":
+			w.WriteHeader(401)
+		case "/gone":
+			w.WriteHeader(410)
+		case "/gone-js":
+			w.Header().Set("Location", "javascript:alert(1)")
+			w.WriteHeader(410)
+		case "/old/file":
+			http.Redirect(w, r, "/new/file", 301)
+		case "/new/file":
+			http.NotFound(w, r)
+		case "/bad":
+			w.Write([]byte(`<meta name="go-import" content="` + r.Host + `/bad git">`))
+		case "/ambiguous":
+			w.Write([]byte(`<meta name="go-import" content="` + r.Host + ` git https://a"><meta name="go-import" content="` + r.Host + ` hg https://b">`))
+		}
+	}))
+	defer ts.Close()
+
+	for path, want := range map[string]ErrorKind{
+		"/limited": ErrorRateLimited,
+		"/quota":   ErrorRateLimited,
+		"/private": ErrorAuthRequired,
+		"/gone":    ErrorMoved,
+	} {
+		_, err := httpGetBytes(context.Background(), http.DefaultClient, ts.URL+path)
+		e, ok := err.(GetError)
+		if !ok || e.Kind != want {
+			t.Errorf("get %s returned %v, want %v", path, err, want)
+		}
+	}
+	_, err := httpGetBytes(context.Background(), http.DefaultClient, ts.URL+"/limited")
+	if e, _ := err.(GetError); e.RetryAfter != 2*time.Minute || !e.Temporary() {
+		t.Errorf("got retry after %v, temporary %v, want 2m0s, true", e.RetryAfter, e.Temporary())
+	}
+
+	_, err = httpGetBytes(context.Background(), http.DefaultClient, ts.URL+"/gone-js")
+	if e, ok := err.(GetError); !ok || e.Kind != ErrorMoved || e.Location != "" {
+		t.Errorf("get with javascript: location returned %v, location %q, want moved without location", err, e.Location)
+	}
+
+	// The client follows the redirect of a moved repository.
+	_, err = httpGetBytes(context.Background(), http.DefaultClient, ts.URL+"/old/file")
+	if e, ok := err.(GetError); !ok || e.Kind != ErrorMoved || e.Location != ts.URL+"/new/file" {
+		t.Errorf("get of moved file returned %v, want moved to %s/new/file", err, ts.URL)
+	}
+
+	defer func(h map[string]bool) { serverHosts = h }(serverHosts)
+	serverHosts = make(map[string]bool)
+	p, err := NewFetchPolicy(nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = httpGetBytes(context.Background(), p.Client(http.DefaultClient), ts.URL+"/gone")
+	if e, ok := err.(GetError); !ok || e.Kind != ErrorRefused || e.Temporary() {
+		t.Errorf("get refused by policy returned %v, want %v", err, ErrorRefused)
+	}
+
+	if e := parseError(ts.URL, json.Unmarshal([]byte("{"), new(struct{}))); e.Kind != ErrorParse || e.Temporary() {
+		t.Errorf("parseError returned %v with kind %v", e, e.Kind)
+	}
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	for path, want := range map[string]ErrorKind{
+		"/bad":       ErrorBadMeta,
+		"/ambiguous": ErrorAmbiguousImport,
+	} {
+		_, _, _, _, _, err := getMeta(context.Background(), http.DefaultClient, host+path)
+		if e, ok := err.(GetError); !ok || e.Kind != want {
+			t.Errorf("getMeta(%s) returned %v, want %v", path, err, want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrorKind classifies the errors fetching a package.
+type ErrorKind int
+
+const (
+	ErrorOther ErrorKind = iota
+
+	// The host limited the rate of requests.
+	ErrorRateLimited
+
+	// The host requires credentials or refused the configured credentials.
+	ErrorAuthRequired
+
+	// The repository moved to the location in the error.
+	ErrorMoved
+
+	// The host did not respond in time.
+	ErrorTimeout
+
+	// The go-import meta tag for the import path is malformed.
+	ErrorBadMeta
+
+	// More than one go-import meta tag matches the import path.
+	ErrorAmbiguousImport
+
+	// The response from the host could not be parsed.
+	ErrorParse
+
+	// The fetch policy refused the address of the host.
+	ErrorRefused
+)
+
+var errorKindNames = []string{
+	"Other",
+	"RateLimited",
+	"AuthRequired",
+	"Moved",
+	"Timeout",
+	"BadMeta",
+	"AmbiguousImport",
+	"Parse",
+	"Refused",
+}
+
+func (k ErrorKind) String() string {
+	if int(k) < len(errorKindNames) {
+		return errorKindNames[k]
+	}
+	return "ErrorKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// GetError is an error fetching a package from Host.
+type GetError struct {
+	Host string
+	Kind ErrorKind
+
+	// Status code of the response from the host or zero if there was no
+	// response.
+	Status int
+
+	// Time to wait before retrying or zero if not known.
+	RetryAfter time.Duration
+
+	// New location of a moved repository.
+	Location string
+
+	err error
+}
+
+func (e GetError) Error() string {
+	if e.err == nil {
+		return "error getting files from " + e.Host
+	}
+	return e.err.Error()
+}
+
+// Temporary returns true if fetching the package later can succeed without
+// a change to the repository or configuration.
+func (e GetError) Temporary() bool {
+	switch e.Kind {
+	case ErrorRateLimited, ErrorTimeout:
+		return true
+	case ErrorOther:
+		return e.Status == 0 || e.Status >= 500
+	}
+	return false
+}
+
+// fetchError returns the error for a failed request to host.
+func fetchError(host string, err error) GetError {
+	e := GetError{Host: host, err: err}
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	if ne, ok := err.(net.Error); (ok && ne.Timeout()) || err == context.DeadlineExceeded {
+		e.Kind = ErrorTimeout
+	}
+	var ae *AddressError
+	if errors.As(err, &ae) {
+		e.Kind = ErrorRefused
+	}
+	return e
+}
+
+// movedTo returns the target of the first permanent redirect followed to get
+// resp or "" if no permanent redirect was followed. The client follows
+// redirects, so a moved repository is found from the redirects of the
+// request.
+func movedTo(resp *http.Response) string {
+	location := ""
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		if s := req.Response.StatusCode; s == 301 || s == 308 {
+			location = req.URL.String()
+		}
+	}
+	return location
+}
+
+// notFoundError returns the error for a response with status 404. If the
+// request was permanently redirected, the repository moved.
+func notFoundError(resp *http.Response) error {
+	if movedTo(resp) != "" {
+		return responseError(resp)
+	}
+	return ErrPackageNotFound
+}
+
+// responseError returns the error for a response with a status other than
+// 200 and 404.
+func responseError(resp *http.Response) GetError {
+	u := resp.Request.URL
+	e := GetError{
+		Host:   u.Host,
+		Status: resp.StatusCode,
+		err:    fmt.Errorf("get %s -> %d", u, resp.StatusCode),
+	}
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil {
+			e.RetryAfter = time.Duration(n) * time.Second
+		} else if t, err := http.ParseTime(s); err == nil {
+			e.RetryAfter = t.Sub(time.Now())
+		}
+	}
+	switch {
+	case resp.StatusCode == 429 ||
+		(resp.StatusCode == 403 && resp.Header.Get("X-RateLimit-Remaining") == "0"):
+		e.Kind = ErrorRateLimited
+		if e.RetryAfter == 0 {
+			// GitHub and Gitea send the time the limit is reset.
+			if n, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				e.RetryAfter = time.Unix(n, 0).Sub(time.Now())
+			}
+		}
+	case resp.StatusCode == 401 || resp.StatusCode == 403:
+		e.Kind = ErrorAuthRequired
+	case resp.StatusCode == 301 || resp.StatusCode == 308 || resp.StatusCode == 410:
+		e.Kind = ErrorMoved
+		// The location is shown as a link. Other schemes are ignored.
+		if loc, err := resp.Location(); err == nil && (loc.Scheme == "http" || loc.Scheme == "https") {
+			e.Location = loc.String()
+		}
+	case resp.StatusCode == 504 || resp.StatusCode == 408:
+		e.Kind = ErrorTimeout
+	}
+	if e.Location == "" && (e.Kind == ErrorMoved || resp.StatusCode == 404) {
+		if loc := movedTo(resp); loc != "" {
+			e.Kind = ErrorMoved
+			e.Location = loc
+		}
+	}
+	if e.RetryAfter < 0 {
+		e.RetryAfter = 0
+	}
+	return e
+}
+
+// parseError returns the error for a response from the service at rawurl
+// that could not be parsed.
+func parseError(rawurl string, err error) GetError {
+	host := rawurl
+	if u, perr := url.Parse(rawurl); perr == nil && u.Host != "" {
+		host = u.Host
+	}
+	return GetError{Host: host, Kind: ErrorParse, err: fmt.Errorf("parse response from %s: %v", host, err)}
+}
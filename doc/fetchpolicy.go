@@ -0,0 +1,277 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// reservedNets is the list of loopback, private, link-local and other
+// special purpose networks that are not fetched unless allowed by the fetch
+// policy.
+var reservedNets = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range cidrs {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// serverHosts is the set of hosts of the configured GitHub Enterprise,
+// GitLab and Gitea servers. The servers are allowed by all fetch policies.
+var serverHosts = make(map[string]bool)
+
+func addServerHosts(rawurls ...string) {
+	for _, s := range rawurls {
+		if u, err := url.Parse(s); err == nil && u.Host != "" {
+			serverHosts[strings.ToLower(hostOnly(u.Host))] = true
+		}
+	}
+}
+
+// hostOnly returns host without the port.
+func hostOnly(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.Trim(host, "[]")
+}
+
+// AddressError is returned when a fetch is refused by the fetch policy.
+type AddressError struct {
+	Host string
+	IP   net.IP
+}
+
+func (e *AddressError) Error() string {
+	if e.IP == nil {
+		return fmt.Sprintf("fetch of %s refused: no allowed address", e.Host)
+	}
+	return fmt.Sprintf("fetch of %s refused: address %s is not public", e.Host, e.IP)
+}
+
+const (
+	defaultMaxRedirects = 5
+	dialTimeout         = 30 * time.Second
+	tlsTimeout          = 10 * time.Second
+)
+
+// lookupIP is replaced by tests.
+var lookupIP = net.LookupIP
+
+// FetchPolicy restricts the network addresses that documentation is fetched
+// from. Hosts that resolve to loopback, private, link-local and other
+// reserved addresses are refused unless the host or address is allowed.
+type FetchPolicy struct {
+	hosts        map[string]bool
+	nets         []*net.IPNet
+	maxRedirects int
+	transport    *http.Transport
+}
+
+// NewFetchPolicy returns a fetch policy that allows the hosts in allowHosts
+// and the networks in CIDR notation in allowNets. Requests are stopped after
+// maxRedirects redirects. If maxRedirects is zero, a default is used.
+func NewFetchPolicy(allowHosts, allowNets []string, maxRedirects int) (*FetchPolicy, error) {
+	nets, err := parseCIDRs(allowNets)
+	if err != nil {
+		return nil, err
+	}
+	if maxRedirects <= 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	p := &FetchPolicy{hosts: make(map[string]bool), nets: nets, maxRedirects: maxRedirects}
+	for _, h := range allowHosts {
+		p.hosts[strings.ToLower(h)] = true
+	}
+	p.transport = &http.Transport{DialContext: p.DialContext, TLSHandshakeTimeout: tlsTimeout}
+	return p, nil
+}
+
+func (p *FetchPolicy) hostAllowed(host string) bool {
+	host = strings.ToLower(host)
+	return p.hosts[host] || serverHosts[host]
+}
+
+func (p *FetchPolicy) ipAllowed(ip net.IP) bool {
+	for _, n := range p.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve returns the addresses of host. An error is returned if any of the
+// addresses is refused. The returned slice is nil if the host is allowed
+// without checking the addresses.
+func (p *FetchPolicy) resolve(host string) ([]net.IP, error) {
+	if p.hostAllowed(host) {
+		return nil, nil
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		ips, err = lookupIP(host)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			return nil, &AddressError{Host: host}
+		}
+	}
+	for _, ip := range ips {
+		if !p.ipAllowed(ip) {
+			return nil, &AddressError{Host: host, IP: ip}
+		}
+	}
+	return ips, nil
+}
+
+// Dial connects to addr after checking the addresses of the host. The
+// connection is made to a checked address so that the host cannot resolve
+// to a different address between the check and the connection.
+func (p *FetchPolicy) Dial(network, addr string) (net.Conn, error) {
+	return p.DialContext(context.Background(), network, addr)
+}
+
+// DialContext is like Dial, but the connection attempt is stopped when ctx
+// is done.
+func (p *FetchPolicy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := p.resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{Timeout: dialTimeout}
+	if ips == nil {
+		return d.DialContext(ctx, network, addr)
+	}
+	for _, ip := range ips {
+		var c net.Conn
+		c, err = d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// Transport returns a transport that dials with the policy. The transport
+// does not use a proxy because the proxy would connect to the checked host.
+func (p *FetchPolicy) Transport() *http.Transport {
+	return p.transport
+}
+
+// checkURL returns an error if the policy refuses requests to u.
+func (p *FetchPolicy) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("fetch of %s refused: unsupported scheme", u)
+	}
+	_, err := p.resolve(hostOnly(u.Host))
+	return err
+}
+
+// Client returns a copy of client that checks the policy before sending
+// every request, including requests for redirects, and that stops after the
+// maximum number of redirects.
+//
+// If the client uses the default transport, the transport is replaced with
+// the transport returned by Transport so that connections are made to the
+// checked addresses. Other transports resolve the host again when
+// connecting. App Engine's urlfetch service cannot be given an address to
+// connect to, so a host that resolves to a public address for the check and
+// to a reserved address for the fetch is not refused on App Engine.
+func (p *FetchPolicy) Client(client *http.Client) *http.Client {
+	base := client.Transport
+	if base == nil || base == http.DefaultTransport {
+		base = p.transport
+	}
+	c := *client
+	c.Transport = &policyTransport{base: base, policy: p}
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= p.maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", p.maxRedirects)
+		}
+		return p.checkURL(req.URL)
+	}
+	return &c
+}
+
+type policyTransport struct {
+	base   http.RoundTripper
+	policy *FetchPolicy
+}
+
+func (t *policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.policy.checkURL(req.URL); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+var fetchPolicy *FetchPolicy
+
+// SetFetchPolicy sets the policy for requests made by Get. Requests are not
+// restricted if the policy is nil. SetFetchPolicy must be called before
+// calling Get.
+func SetFetchPolicy(p *FetchPolicy) {
+	fetchPolicy = p
+}
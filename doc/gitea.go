@@ -0,0 +1,157 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// giteaServer is a Gitea or Forgejo instance. Forgejo has the same API as
+// Gitea.
+type giteaServer struct {
+	host    string
+	baseURL string
+	header  http.Header
+}
+
+// AddGiteaServer adds the Gitea or Forgejo instance at baseURL as the service
+// for import paths starting with host. If token is not "", the token is sent
+// with requests to the instance. Servers must be added before calling Get.
+func AddGiteaServer(host, baseURL, token string) {
+	s := &giteaServer{
+		host:    host,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		header:  tokenHeader("token", token),
+	}
+	addServerHosts(s.baseURL)
+	addService(&service{ownerRepoPattern(host), s.getDoc, host + "/"})
+}
+
+func (s *giteaServer) getDoc(ctx context.Context, client *http.Client, m []string, savedEtag string) (*Package, error) {
+	importPath := m[0]
+	projectRoot := s.host + "/" + m[1] + "/" + m[2]
+	projectName := m[2]
+	projectURL := s.baseURL + "/" + m[1] + "/" + m[2] + "/"
+	dir := normalizeDir(m[3])
+	api := s.baseURL + "/api/v1/repos/" + m[1] + "/" + m[2]
+
+	p, err := httpGetBytesHeader(ctx, client, api, s.header)
+	if err != nil {
+		return nil, err
+	}
+	var repo struct {
+		DefaultBranch string `json:"default_branch"`
+		Empty         bool
+	}
+	if err := json.Unmarshal(p, &repo); err != nil {
+		return nil, parseError(s.baseURL, err)
+	}
+	if repo.Empty || repo.DefaultBranch == "" {
+		return nil, ErrPackageNotFound
+	}
+	ref := repo.DefaultBranch
+
+	p, err = httpGetBytesHeader(ctx, client, api+"/branches/"+url.QueryEscape(ref), s.header)
+	if err != nil {
+		return nil, err
+	}
+	var branch struct {
+		Commit struct {
+			Id string
+		}
+	}
+	if err := json.Unmarshal(p, &branch); err != nil {
+		return nil, parseError(s.baseURL, err)
+	}
+	etag := branch.Commit.Id
+	if etag == savedEtag {
+		return nil, ErrPackageNotModified
+	}
+
+	// Walk the pages of the recursive tree listing for the commit.
+	inTree := false
+	var files []*source
+	goMods := make(map[string]*source)
+	for page := 1; ; page++ {
+		p, err = httpGetBytesHeader(ctx, client, api+"/git/trees/"+etag+"?recursive=true&page="+strconv.Itoa(page), s.header)
+		if err != nil {
+			return nil, err
+		}
+		var tree struct {
+			Tree []struct {
+				Path string
+				Type string
+			}
+			Truncated bool
+		}
+		if err := json.Unmarshal(p, &tree); err != nil {
+			return nil, parseError(s.baseURL, err)
+		}
+		for _, node := range tree.Tree {
+			if node.Type != "blob" {
+				continue
+			}
+			rawURL := api + "/raw/" + escapePath(node.Path) + "?ref=" + etag
+			browseURL := projectURL + "src/commit/" + etag + "/" + escapePath(node.Path)
+			if path.Base(node.Path) == "go.mod" {
+				goMods[node.Path] = &source{browseURL: browseURL, rawURL: rawURL}
+			}
+			if !isDocFile(node.Path) {
+				continue
+			}
+			if d, f := path.Split(node.Path); d == dir {
+				// A directory with only subpackages is not a package.
+				inTree = true
+				files = append(files, &source{
+					name:      f,
+					browseURL: browseURL,
+					rawURL:    rawURL,
+				})
+			}
+		}
+		if !tree.Truncated || len(tree.Tree) == 0 {
+			break
+		}
+	}
+
+	if !inTree {
+		return nil, ErrPackageNotFound
+	}
+
+	if src := nearestGoMod(dir, goMods); src != nil {
+		files = append(files, src)
+	}
+
+	if err := fetchFiles(ctx, client, files, s.header); err != nil {
+		return nil, err
+	}
+
+	return buildDoc(importPath, projectRoot, projectName, projectURL, etag, "#L%d", files)
+}
+
+// escapePath escapes each element of the slash separated path p.
+func escapePath(p string) string {
+	elems := strings.Split(p, "/")
+	for i := range elems {
+		elems[i] = url.PathEscape(elems[i])
+	}
+	return strings.Join(elems, "/")
+}
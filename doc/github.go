@@ -15,25 +15,64 @@
 package doc
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"path"
-	"regexp"
 	"strings"
 )
 
 var githubRawHeader = http.Header{"Accept": {"application/vnd.github-blob.raw"}}
-var githubPattern = regexp.MustCompile(`^github\.com/([a-z0-9A-Z_.\-]+)/([a-z0-9A-Z_.\-]+)(/[a-z0-9A-Z_.\-/]*)?$`)
+var githubPattern = ownerRepoPattern("github.com")
 
-func getGithubDoc(client *http.Client, m []string, savedEtag string) (*Package, error) {
+// githubServer is a GitHub or GitHub Enterprise instance.
+type githubServer struct {
+	host    string
+	apiURL  string
+	webURL  string
+	header  http.Header
+	rawHead http.Header
+}
+
+var githubDotCom = newGithubServer("github.com", "https://api.github.com", "https://github.com", "")
+
+func newGithubServer(host, apiURL, webURL, token string) *githubServer {
+	s := &githubServer{
+		host:    host,
+		apiURL:  strings.TrimSuffix(apiURL, "/"),
+		webURL:  strings.TrimSuffix(webURL, "/"),
+		header:  tokenHeader("token", token),
+		rawHead: tokenHeader("token", token),
+	}
+	if s.rawHead == nil {
+		s.rawHead = make(http.Header)
+	}
+	for k, v := range githubRawHeader {
+		s.rawHead[k] = v
+	}
+	return s
+}
+
+// AddGithubServer adds the GitHub Enterprise instance with the API at apiURL
+// and the web interface at webURL as the service for import paths starting
+// with host. GitHub Enterprise serves the API at webURL + "/api/v3". If token
+// is not "", the token is sent with requests to the API. Servers must be
+// added before calling Get.
+func AddGithubServer(host, apiURL, webURL, token string) {
+	s := newGithubServer(host, apiURL, webURL, token)
+	addServerHosts(s.apiURL, s.webURL)
+	addService(&service{ownerRepoPattern(host), s.getDoc, host + "/"})
+}
+
+func (s *githubServer) getDoc(ctx context.Context, client *http.Client, m []string, savedEtag string) (*Package, error) {
 	importPath := m[0]
-	projectRoot := "github.com/" + m[1] + "/" + m[2]
+	projectRoot := s.host + "/" + m[1] + "/" + m[2]
 	projectName := m[2]
-	projectURL := "https://github.com/" + m[1] + "/" + m[2] + "/"
+	projectURL := s.webURL + "/" + m[1] + "/" + m[2] + "/"
 	userRepo := m[1] + "/" + m[2]
 	dir := normalizeDir(m[3])
 
-	p, err := httpGetBytes(client, "https://api.github.com/repos/"+userRepo+"/git/refs")
+	p, err := httpGetBytesHeader(ctx, client, s.apiURL+"/repos/"+userRepo+"/git/refs", s.header)
 	if err != nil {
 		return nil, err
 	}
@@ -49,7 +88,7 @@ func getGithubDoc(client *http.Client, m []string, savedEtag string) (*Package,
 	}
 
 	if err := json.Unmarshal(p, &refs); err != nil {
-		return nil, err
+		return nil, parseError(s.apiURL, err)
 	}
 
 	etag := ""
@@ -68,7 +107,7 @@ func getGithubDoc(client *http.Client, m []string, savedEtag string) (*Package,
 		return nil, ErrPackageNotModified
 	}
 
-	p, err = httpGetBytes(client, "https://api.github.com/repos/"+userRepo+"/git/trees/"+treeName+"?recursive=1")
+	p, err = httpGetBytesHeader(ctx, client, s.apiURL+"/repos/"+userRepo+"/git/trees/"+treeName+"?recursive=1", s.header)
 	if err != nil {
 		return nil, err
 	}
@@ -81,12 +120,19 @@ func getGithubDoc(client *http.Client, m []string, savedEtag string) (*Package,
 		}
 	}
 	if err := json.Unmarshal(p, &tree); err != nil {
-		return nil, err
+		return nil, parseError(s.apiURL, err)
 	}
 
 	inTree := false
 	var files []*source
+	goMods := make(map[string]*source)
 	for _, node := range tree.Tree {
+		if node.Type == "blob" && path.Base(node.Path) == "go.mod" {
+			goMods[node.Path] = &source{
+				browseURL: s.webURL + "/" + userRepo + "/blob/" + treeName + "/" + node.Path,
+				rawURL:    node.Url,
+			}
+		}
 		if node.Type != "blob" ||
 			!isDocFile(node.Path) ||
 			!strings.HasPrefix(node.Path, dir) {
@@ -96,7 +142,7 @@ func getGithubDoc(client *http.Client, m []string, savedEtag string) (*Package,
 		if d, f := path.Split(node.Path); d == dir {
 			files = append(files, &source{
 				name:      f,
-				browseURL: "https://github.com/" + userRepo + "/blob/" + treeName + "/" + node.Path,
+				browseURL: s.webURL + "/" + userRepo + "/blob/" + treeName + "/" + node.Path,
 				rawURL:    node.Url,
 			})
 		}
@@ -106,7 +152,11 @@ func getGithubDoc(client *http.Client, m []string, savedEtag string) (*Package,
 		return nil, ErrPackageNotFound
 	}
 
-	if err := fetchFiles(client, files, githubRawHeader); err != nil {
+	if src := nearestGoMod(dir, goMods); src != nil {
+		files = append(files, src)
+	}
+
+	if err := fetchFiles(ctx, client, files, s.rawHead); err != nil {
 		return nil, err
 	}
 
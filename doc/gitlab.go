@@ -0,0 +1,225 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var gitlabPattern = gitlabPathPattern("gitlab.com")
+
+// gitlabPathPattern returns the pattern for import paths on the GitLab
+// instance at host. Projects can be nested in subgroups, so the project path
+// is found by getDoc.
+func gitlabPathPattern(host string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(host) + `/([a-z0-9A-Z_.\-]+(?:/[a-z0-9A-Z_.\-]+)+)/?$`)
+}
+
+// gitlabServer is a GitLab instance.
+type gitlabServer struct {
+	host    string
+	baseURL string
+	header  http.Header
+
+	// Project paths found by getProject.
+	mu       sync.Mutex
+	projects map[string]bool
+}
+
+var gitlabDotCom = &gitlabServer{host: "gitlab.com", baseURL: "https://gitlab.com"}
+
+// AddGitlabServer adds the GitLab instance at baseURL as the service for
+// import paths starting with host. If token is not "", the token is sent with
+// requests to the instance. AddGitlabServer can also be used to set the token
+// for gitlab.com. Servers must be added before calling Get.
+func AddGitlabServer(host, baseURL, token string) {
+	s := &gitlabServer{host: host, baseURL: strings.TrimSuffix(baseURL, "/")}
+	s.header = tokenHeader("Bearer", token)
+	addServerHosts(s.baseURL)
+	addService(&service{gitlabPathPattern(host), s.getDoc, host + "/"})
+}
+
+// gitlabProject is the project returned by the GitLab API.
+type gitlabProject struct {
+	DefaultBranch string `json:"default_branch"`
+
+	// Path of the project relative to the server.
+	path string
+}
+
+// maxGitlabProjectDepth is the maximum number of path elements in a project
+// path. GitLab allows 20 levels of nested subgroups.
+const maxGitlabProjectDepth = 21
+
+// getProject returns the project containing the directory at path and the
+// directory relative to the project. A project path cannot be a prefix of
+// another project path, so the prefixes of path are probed from shortest to
+// longest. A project path found by an earlier call is tried first.
+func (s *gitlabServer) getProject(ctx context.Context, client *http.Client, path string) (*gitlabProject, string, error) {
+	elems := strings.Split(path, "/")
+	cached := 0
+	s.mu.Lock()
+	for n := 2; n <= len(elems); n++ {
+		if s.projects[strings.Join(elems[:n], "/")] {
+			cached = n
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	try := func(n int) (*gitlabProject, string, error) {
+		projectPath := strings.Join(elems[:n], "/")
+		p, err := httpGetBytesHeader(ctx, client, s.baseURL+"/api/v4/projects/"+url.QueryEscape(projectPath), s.header)
+		s.mu.Lock()
+		switch {
+		case err == ErrPackageNotFound:
+			delete(s.projects, projectPath)
+		case err == nil:
+			if s.projects == nil {
+				s.projects = make(map[string]bool)
+			}
+			s.projects[projectPath] = true
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return nil, "", err
+		}
+		var project gitlabProject
+		if err := json.Unmarshal(p, &project); err != nil {
+			return nil, "", parseError(s.baseURL, err)
+		}
+		project.path = projectPath
+		dir := ""
+		if n < len(elems) {
+			dir = strings.Join(elems[n:], "/") + "/"
+		}
+		return &project, dir, nil
+	}
+
+	if cached != 0 {
+		if project, dir, err := try(cached); err != ErrPackageNotFound {
+			return project, dir, err
+		}
+	}
+	for n := 2; n <= len(elems) && n <= maxGitlabProjectDepth; n++ {
+		if n == cached {
+			continue
+		}
+		if project, dir, err := try(n); err != ErrPackageNotFound {
+			return project, dir, err
+		}
+	}
+	return nil, "", ErrPackageNotFound
+}
+
+func (s *gitlabServer) getDoc(ctx context.Context, client *http.Client, m []string, savedEtag string) (*Package, error) {
+	importPath := m[0]
+	project, dir, err := s.getProject(ctx, client, m[1])
+	if err != nil {
+		return nil, err
+	}
+	projectPath := project.path
+	projectRoot := s.host + "/" + projectPath
+	projectName := projectPath[strings.LastIndex(projectPath, "/")+1:]
+	projectURL := s.baseURL + "/" + projectPath + "/"
+	api := s.baseURL + "/api/v4/projects/" + url.QueryEscape(projectPath)
+
+	if project.DefaultBranch == "" {
+		// The repository is empty.
+		return nil, ErrPackageNotFound
+	}
+	ref := project.DefaultBranch
+
+	p, err := httpGetBytesHeader(ctx, client, api+"/repository/branches/"+url.QueryEscape(ref), s.header)
+	if err != nil {
+		return nil, err
+	}
+	var branch struct {
+		Commit struct {
+			Id string
+		}
+	}
+	if err := json.Unmarshal(p, &branch); err != nil {
+		return nil, parseError(s.baseURL, err)
+	}
+	etag := branch.Commit.Id
+	if etag == savedEtag {
+		return nil, ErrPackageNotModified
+	}
+
+	// List the directory. The tree is fetched by commit so that the files
+	// match the etag.
+	const perPage = 100
+	inTree := false
+	var files []*source
+	for page := 1; ; page++ {
+		p, err = httpGetBytesHeader(ctx, client, api+"/repository/tree?ref="+etag+
+			"&path="+url.QueryEscape(strings.TrimSuffix(dir, "/"))+
+			"&per_page="+strconv.Itoa(perPage)+"&page="+strconv.Itoa(page), s.header)
+		if err != nil {
+			return nil, err
+		}
+		var tree []struct {
+			Name string
+			Path string
+			Type string
+		}
+		if err := json.Unmarshal(p, &tree); err != nil {
+			return nil, parseError(s.baseURL, err)
+		}
+		for _, node := range tree {
+			inTree = true
+			if node.Type != "blob" || !isDocFile(node.Path) {
+				continue
+			}
+			files = append(files, &source{
+				name:      node.Name,
+				browseURL: projectURL + "-/blob/" + etag + "/" + node.Path,
+				rawURL:    api + "/repository/files/" + url.QueryEscape(node.Path) + "/raw?ref=" + etag,
+			})
+		}
+		if len(tree) < perPage {
+			break
+		}
+	}
+
+	if !inTree {
+		return nil, ErrPackageNotFound
+	}
+
+	if err := fetchFiles(ctx, client, files, s.header); err != nil {
+		return nil, err
+	}
+
+	src, err := fetchGoMod(ctx, client, dir, s.header, func(p string) (string, string) {
+		return api + "/repository/files/" + url.QueryEscape(p) + "/raw?ref=" + etag,
+			projectURL + "-/blob/" + etag + "/" + p
+	})
+	if err != nil {
+		return nil, err
+	}
+	if src != nil {
+		files = append(files, src)
+	}
+
+	return buildDoc(importPath, projectRoot, projectName, projectURL, etag, "#L%d", files)
+}
@@ -15,11 +15,9 @@
 package doc
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
+	"context"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"path"
 	"regexp"
@@ -28,7 +26,7 @@ import (
 
 var gitoriousPattern = regexp.MustCompile(`^git\.gitorious\.org/([a-z0-9A-Z_.\-]+)/([a-z0-9A-Z_.\-]+)\.git(/[a-z0-9A-Z_.\-/]*)?$`)
 
-func getGitoriousDoc(client *http.Client, m []string, savedEtag string) (*Package, error) {
+func getGitoriousDoc(ctx context.Context, client *http.Client, m []string, savedEtag string) (*Package, error) {
 
 	importPath := m[0]
 	projectRoot := "git.gitorious.org/" + m[1] + "/" + m[2] + ".git"
@@ -36,27 +34,32 @@ func getGitoriousDoc(client *http.Client, m []string, savedEtag string) (*Packag
 	projectURL := "https://gitorious.org/" + m[1] + "/" + m[2] + "/"
 	dir := normalizeDir(m[3])
 
-	p, etag, err := httpGetBytesCompare(client, "https://gitorious.org/"+m[1]+"/"+m[2]+"/archive-tarball/master", savedEtag)
+	p, etag, err := httpGetBytesCompare(ctx, client, "https://gitorious.org/"+m[1]+"/"+m[2]+"/archive-tarball/master", savedEtag)
 	if err != nil {
 		return nil, err
 	}
 
-	gzr, err := gzip.NewReader(bytes.NewReader(p))
+	ar, err := newArchiveReader(bytes.NewReader(p), importPath)
 	if err != nil {
 		return nil, err
 	}
-	defer gzr.Close()
-
-	tr := tar.NewReader(gzr)
 
 	inTree := false
-	prefix := m[1] + "-" + m[2] + "/" + dir
+	prefix := m[1] + "-" + m[2] + "/"
 	var files []*source
+	goMods := make(map[string]*source)
 	for {
-		hdr, err := tr.Next()
+		hdr, err := ar.next()
 		if err == io.EOF {
 			break
 		}
+		if isLimitError(err) {
+			if !inTree {
+				return nil, err
+			}
+			files = append(files, &source{err: err})
+			break
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -64,18 +67,36 @@ func getGitoriousDoc(client *http.Client, m []string, savedEtag string) (*Packag
 			continue
 		}
 		name := hdr.Name[len(prefix):]
-		if !isDocFile(name) {
+		if path.Base(name) == "go.mod" {
+			b, err := ar.read(name)
+			if isLimitError(err) {
+				files = append(files, &source{err: err})
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			goMods[name] = &source{
+				browseURL: "https://gitorious.org/" + m[1] + "/" + m[2] + "/blobs/master/" + name,
+				data:      b}
+			continue
+		}
+		if !isDocFile(name) || !strings.HasPrefix(name, dir) {
 			continue
 		}
 		inTree = true
-		if d, _ := path.Split(name); d == "" {
-			b, err := ioutil.ReadAll(tr)
+		if d, f := path.Split(name); d == dir {
+			b, err := ar.read(name)
+			if isLimitError(err) {
+				files = append(files, &source{name: f, err: err})
+				continue
+			}
 			if err != nil {
 				return nil, err
 			}
 			files = append(files, &source{
-				name:      name,
-				browseURL: "https://gitorious.org/" + m[1] + "/" + m[2] + "/blobs/master/" + dir + name,
+				name:      f,
+				browseURL: "https://gitorious.org/" + m[1] + "/" + m[2] + "/blobs/master/" + name,
 				data:      b})
 		}
 	}
@@ -84,5 +105,9 @@ func getGitoriousDoc(client *http.Client, m []string, savedEtag string) (*Packag
 		return nil, ErrPackageNotFound
 	}
 
+	if src := nearestGoMod(dir, goMods); src != nil {
+		files = append(files, src)
+	}
+
 	return buildDoc(importPath, projectRoot, projectName, projectURL, etag, "#line%d", files)
 }
@@ -15,6 +15,7 @@
 package doc
 
 import (
+	"context"
 	"net/http"
 	"regexp"
 	"strings"
@@ -24,7 +25,7 @@ var googleRepoRe = regexp.MustCompile(`id="checkoutcmd">(hg|git|svn)`)
 var googleFilePattern = regexp.MustCompile(`<li><a href="([^"/]+)"`)
 var googlePattern = regexp.MustCompile(`^code\.google\.com/p/([a-z0-9\-]+)(\.[a-z0-9\-]+)?(/[a-z0-9A-Z_.\-/]+)?$`)
 
-func getGoogleDoc(client *http.Client, m []string, savedEtag string) (*Package, error) {
+func getGoogleDoc(ctx context.Context, client *http.Client, m []string, savedEtag string) (*Package, error) {
 
 	importPath := m[0]
 	projectRoot := "code.google.com/p/" + m[1] + m[2]
@@ -40,7 +41,7 @@ func getGoogleDoc(client *http.Client, m []string, savedEtag string) (*Package,
 	dir := normalizeDir(m[3])
 
 	// Scrape the HTML project page to find the VCS.
-	p, err := httpGetBytes(client, "http://code.google.com/p/"+repo+"/source/checkout")
+	p, err := httpGetBytes(ctx, client, "http://code.google.com/p/"+repo+"/source/checkout")
 	if err != nil {
 		return nil, err
 	}
@@ -53,7 +54,7 @@ func getGoogleDoc(client *http.Client, m []string, savedEtag string) (*Package,
 	}
 
 	// Scrape the repo browser to find individual Go files.
-	p, etag, err := httpGetBytesCompare(client, "http://"+subrepo+repo+".googlecode.com/"+vcs+"/"+dir, savedEtag)
+	p, etag, err := httpGetBytesCompare(ctx, client, "http://"+subrepo+repo+".googlecode.com/"+vcs+"/"+dir, savedEtag)
 	if err != nil {
 		return nil, err
 	}
@@ -74,17 +75,28 @@ func getGoogleDoc(client *http.Client, m []string, savedEtag string) (*Package,
 		}
 	}
 
-	if err := fetchFiles(client, files, nil); err != nil {
+	if err := fetchFiles(ctx, client, files, nil); err != nil {
 		return nil, err
 	}
 
+	src, err := fetchGoMod(ctx, client, dir, nil, func(p string) (string, string) {
+		return "http://" + subrepo + repo + ".googlecode.com/" + vcs + "/" + p,
+			"http://code.google.com/p/" + repo + "/source/browse/" + p + query
+	})
+	if err != nil {
+		return nil, err
+	}
+	if src != nil {
+		files = append(files, src)
+	}
+
 	return buildDoc(importPath, projectRoot, projectName, projectURL, etag, "#%d", files)
 }
 
-func getStandardDoc(client *http.Client, importPath string, savedEtag string) (*Package, error) {
+func getStandardDoc(ctx context.Context, client *http.Client, importPath string, savedEtag string) (*Package, error) {
 
 	// Scrape the repo browser to find individual Go files.
-	p, etag, err := httpGetBytesCompare(client, "http://go.googlecode.com/hg-history/release/src/pkg/"+importPath+"/", savedEtag)
+	p, etag, err := httpGetBytesCompare(ctx, client, "http://go.googlecode.com/hg-history/release/src/pkg/"+importPath+"/", savedEtag)
 	if err != nil {
 		return nil, err
 	}
@@ -101,7 +113,7 @@ func getStandardDoc(client *http.Client, importPath string, savedEtag string) (*
 		}
 	}
 
-	if err := fetchFiles(client, files, nil); err != nil {
+	if err := fetchFiles(ctx, client, files, nil); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,419 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"bufio"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// apiVersions maps "importPath.Name" for exported top-level identifiers and
+// "importPath.Type.Name" for methods and struct fields in the standard
+// packages to the Go version that added the identifier.
+var apiVersions map[string]string
+
+// apiTypes maps the keys in apiVersions for functions, methods, variables and
+// struct fields to "importPath.Type" for the named type of the value or the
+// first result. Identifiers with unnamed types are not in the map.
+var apiTypes map[string]string
+
+// apiPackages is the set of standard packages in the API history.
+var apiPackages map[string]bool
+
+var (
+	apiFilePattern    = regexp.MustCompile(`^go1(\.[0-9]+)?\.txt$`)
+	apiPackagePattern = regexp.MustCompile(`^pkg ([^ ,]+)`)
+	apiLinePattern    = regexp.MustCompile(`^pkg ([^ ,]+)(?: \([^)]*\))?, (func|const|var|type) ([A-Za-z0-9_]+)(.*)$`)
+	apiMemberPattern  = regexp.MustCompile(`^pkg ([^ ,]+)(?: \([^)]*\))?, (?:method \(\*?([A-Za-z0-9_]+)(?:\[[^\]]*\])?\) |type ([A-Za-z0-9_]+)(?:\[[^\]]*\])? (?:struct|interface), )([A-Za-z0-9_]+)(.*)$`)
+	apiIssuePattern   = regexp.MustCompile(` #[0-9]+$`)
+)
+
+// apiType is the type of an identifier as written in an API history file.
+type apiType struct {
+	importPath string
+	expr       string
+}
+
+// LoadAPIHistory loads the Go API history files (go1.txt, go1.1.txt, ...) in
+// dir. The history is used to find the minimum Go version required by a
+// package. Versions are not computed if the history is not loaded.
+func LoadAPIHistory(dir string) error {
+	names, err := filepath.Glob(filepath.Join(dir, "go1*.txt"))
+	if err != nil {
+		return err
+	}
+	versions := make(map[string]string)
+	types := make(map[string]apiType)
+	packages := make(map[string]bool)
+	for _, name := range names {
+		base := filepath.Base(name)
+		if !apiFilePattern.MatchString(base) {
+			continue
+		}
+		version := strings.TrimSuffix(base, ".txt")
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			line := s.Text()
+			if m := apiPackagePattern.FindStringSubmatch(line); m != nil {
+				packages[m[1]] = true
+			}
+			if strings.HasSuffix(line, "//deprecated") {
+				continue
+			}
+			line = apiIssuePattern.ReplaceAllString(line, "")
+			var importPath, key, rest string
+			if m := apiMemberPattern.FindStringSubmatch(line); m != nil {
+				importPath, key, rest = m[1], m[1]+"."+m[2]+m[3]+"."+m[4], m[5]
+				if m[4] == "embedded" || m[4] == "unexported" {
+					continue
+				}
+			} else if m := apiLinePattern.FindStringSubmatch(line); m != nil {
+				importPath, key = m[1], m[1]+"."+m[3]
+				if m[2] == "func" || m[2] == "var" {
+					rest = m[4]
+				}
+			} else {
+				continue
+			}
+			if v, ok := versions[key]; !ok || compareGoVersions(version, v) < 0 {
+				versions[key] = version
+			}
+			if _, ok := types[key]; !ok {
+				if expr := apiResultType(rest); expr != "" {
+					types[key] = apiType{importPath: importPath, expr: expr}
+				}
+			}
+		}
+		err = s.Err()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	apiVersions = versions
+	apiTypes = resolveAPITypes(types, versions, packages)
+	apiPackages = packages
+	return nil
+}
+
+// apiResultType returns the type of a variable or struct field or the first
+// result of a function or method from the rest of an API history line after
+// the identifier.
+func apiResultType(rest string) string {
+	if !strings.HasPrefix(rest, "(") {
+		// A variable or field.
+		return strings.TrimPrefix(rest, " ")
+	}
+	// Skip the parameters.
+	depth := 0
+	for i, c := range rest {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 {
+			rest = strings.TrimPrefix(rest[i+1:], " ")
+			break
+		}
+	}
+	if strings.HasPrefix(rest, "(") {
+		rest = rest[1:]
+		if i := strings.IndexAny(rest, ",)"); i >= 0 {
+			rest = rest[:i]
+		}
+	}
+	return rest
+}
+
+// resolveAPITypes returns a map of the keys in types to "importPath.Type" for
+// the named types in the standard packages.
+func resolveAPITypes(types map[string]apiType, versions map[string]string, packages map[string]bool) map[string]string {
+	byName := make(map[string][]string)
+	for importPath := range packages {
+		name := importPath[strings.LastIndex(importPath, "/")+1:]
+		byName[name] = append(byName[name], importPath)
+	}
+	for _, paths := range byName {
+		sort.Strings(paths)
+	}
+	resolved := make(map[string]string)
+	for key, t := range types {
+		expr := strings.TrimPrefix(t.expr, "*")
+		if i := strings.Index(expr, "["); i > 0 {
+			expr = expr[:i]
+		}
+		importPath, name := t.importPath, expr
+		if i := strings.Index(expr, "."); i >= 0 {
+			importPath, name = "", expr[i+1:]
+			for _, p := range byName[expr[:i]] {
+				if p == t.importPath {
+					// Identifiers in the package are not qualified.
+					continue
+				}
+				if _, ok := versions[p+"."+name]; ok {
+					importPath = p
+					break
+				}
+			}
+		}
+		if importPath == "" || !ast.IsExported(name) {
+			continue
+		}
+		if _, ok := versions[importPath+"."+name]; ok {
+			resolved[key] = importPath + "." + name
+		}
+	}
+	return resolved
+}
+
+// noAPIPackages is the set of standard packages that do not have entries in
+// the API history.
+var noAPIPackages = map[string]bool{
+	"runtime/race": true,
+	"syscall/js":   true,
+	"time/tzdata":  true,
+	"unsafe":       true,
+}
+
+// isStandardPackage returns true if importPath is a standard package in
+// StandardPackages or in the API history.
+func isStandardPackage(importPath string) bool {
+	return StandardPackages[importPath] || apiPackages[importPath] || noAPIPackages[importPath]
+}
+
+// goMinor returns the minor version number of a Go version of the form
+// "go1", "go1.N", "1.N" or "1.N.P".
+func goMinor(version string) int {
+	version = strings.TrimPrefix(version, "go")
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return 0
+	}
+	n, _ := strconv.Atoi(parts[1])
+	return n
+}
+
+// compareGoVersions returns -1, 0 or 1 if Go version a is less than, equal to
+// or greater than Go version b.
+func compareGoVersions(a, b string) int {
+	switch ma, mb := goMinor(a), goMinor(b); {
+	case ma < mb:
+		return -1
+	case ma > mb:
+		return 1
+	}
+	return 0
+}
+
+// goDirective returns the Go version declared in the go.mod file for the
+// package or "" if not known.
+func (b *builder) goDirective() string {
+	if b.pkg.Module == nil {
+		return ""
+	}
+	return b.pkg.Module.GoVersion
+}
+
+// maxTypeDepth limits the expressions followed when finding the type of an
+// expression.
+const maxTypeDepth = 10
+
+// apiResolver finds the standard package identifiers used in a file. The
+// types of expressions are found from the declarations in the file and the
+// API history. Expressions with types declared in the package or found
+// through other files are not resolved.
+type apiResolver struct {
+	importPaths map[string]string
+	depth       int
+}
+
+// key returns the key in apiVersions for the identifier selected by sel or
+// "" if the identifier is not known.
+func (r *apiResolver) key(sel *ast.SelectorExpr) string {
+	if id, ok := sel.X.(*ast.Ident); ok && id.Obj == nil {
+		if importPath := r.importPaths[id.Name]; importPath != "" {
+			return importPath + "." + sel.Sel.Name
+		}
+	}
+	if t := r.exprType(sel.X); t != "" {
+		return t + "." + sel.Sel.Name
+	}
+	return ""
+}
+
+// typeExprType returns "importPath.Type" for a type expression naming a type
+// in a standard package.
+func (r *apiResolver) typeExprType(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.ParenExpr:
+		return r.typeExprType(x.X)
+	case *ast.StarExpr:
+		return r.typeExprType(x.X)
+	case *ast.IndexExpr:
+		return r.typeExprType(x.X)
+	case *ast.SelectorExpr:
+		if key := r.key(x); key != "" {
+			if _, ok := apiVersions[key]; ok {
+				return key
+			}
+		}
+	}
+	return ""
+}
+
+// exprType returns "importPath.Type" for an expression with a type in a
+// standard package. Pointers are dereferenced.
+func (r *apiResolver) exprType(x ast.Expr) string {
+	if r.depth >= maxTypeDepth {
+		return ""
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+
+	switch x := x.(type) {
+	case *ast.ParenExpr:
+		return r.exprType(x.X)
+	case *ast.StarExpr:
+		return r.exprType(x.X)
+	case *ast.UnaryExpr:
+		if x.Op == token.AND {
+			return r.exprType(x.X)
+		}
+	case *ast.CompositeLit:
+		return r.typeExprType(x.Type)
+	case *ast.CallExpr:
+		switch fun := x.Fun.(type) {
+		case *ast.Ident:
+			if fun.Name == "new" && fun.Obj == nil && len(x.Args) == 1 {
+				return r.typeExprType(x.Args[0])
+			}
+		case *ast.SelectorExpr:
+			return apiTypes[r.key(fun)]
+		}
+	case *ast.SelectorExpr:
+		return apiTypes[r.key(x)]
+	case *ast.Ident:
+		if x.Obj != nil && x.Obj.Kind == ast.Var {
+			return r.declType(x.Name, x.Obj.Decl)
+		}
+	}
+	return ""
+}
+
+// declType returns the type of the variable name declared by decl.
+func (r *apiResolver) declType(name string, decl interface{}) string {
+	switch decl := decl.(type) {
+	case *ast.Field:
+		return r.typeExprType(decl.Type)
+	case *ast.ValueSpec:
+		if decl.Type != nil {
+			return r.typeExprType(decl.Type)
+		}
+		for i, id := range decl.Names {
+			if id.Name != name {
+				continue
+			}
+			switch {
+			case len(decl.Values) == len(decl.Names):
+				return r.exprType(decl.Values[i])
+			case len(decl.Values) == 1 && i == 0:
+				return r.exprType(decl.Values[0])
+			}
+		}
+	case *ast.AssignStmt:
+		for i, lhs := range decl.Lhs {
+			if id, ok := lhs.(*ast.Ident); !ok || id.Name != name {
+				continue
+			}
+			switch {
+			case len(decl.Rhs) == len(decl.Lhs):
+				return r.exprType(decl.Rhs[i])
+			case len(decl.Rhs) == 1 && i == 0:
+				return r.exprType(decl.Rhs[0])
+			}
+		}
+	}
+	return ""
+}
+
+// apiUses calls fn for each use of an identifier from the API history in the
+// files of the package.
+func (b *builder) apiUses(fn func(sel *ast.SelectorExpr, key, version string)) {
+	var names []string
+	for name := range b.ast.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := &apiResolver{importPaths: b.fileImportPaths(name)}
+		ast.Inspect(b.ast.Files[name], func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if key := r.key(sel); key != "" {
+					if version, ok := apiVersions[key]; ok {
+						fn(sel, key, version)
+					}
+				}
+			}
+			return true
+		})
+	}
+}
+
+// minGoVersion returns the minimum Go version required by the package's use
+// of the standard library or "" if the API history is not loaded.
+func (b *builder) minGoVersion() string {
+	if apiVersions == nil {
+		return ""
+	}
+	minVersion := "go1"
+	b.apiUses(func(sel *ast.SelectorExpr, key, version string) {
+		if compareGoVersions(version, minVersion) > 0 {
+			minVersion = version
+		}
+	})
+	return minVersion
+}
+
+// checkAPIVersions reports uses of standard package identifiers added after
+// the version declared in go.mod.
+func checkAPIVersions(pass *Pass) {
+	declared := pass.b.goDirective()
+	if apiVersions == nil || declared == "" {
+		return
+	}
+	pass.b.apiUses(func(sel *ast.SelectorExpr, key, version string) {
+		if compareGoVersions(version, declared) > 0 {
+			pass.Reportf(sel.Pos(), SeverityWarning, "%s requires %s, go.mod declares go %s",
+				key, version, declared)
+		}
+	})
+}
+
+func init() {
+	RegisterAnalyzer(&Analyzer{Name: "apiversion", Run: checkAPIVersions})
+}
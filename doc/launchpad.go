@@ -15,11 +15,9 @@
 package doc
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
+	"context"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"path"
 	"regexp"
@@ -28,10 +26,10 @@ import (
 
 var launchpadPattern = regexp.MustCompile(`^launchpad\.net/(([a-z0-9A-Z_.\-]+)(/[a-z0-9A-Z_.\-]+)?|~[a-z0-9A-Z_.\-]+/(\+junk|[a-z0-9A-Z_.\-]+)/[a-z0-9A-Z_.\-]+)(/[a-z0-9A-Z_.\-/]+)*$`)
 
-func getLaunchpadDoc(client *http.Client, m []string, savedEtag string) (*Package, error) {
+func getLaunchpadDoc(ctx context.Context, client *http.Client, m []string, savedEtag string) (*Package, error) {
 
 	if m[2] != "" && m[3] != "" {
-		rc, err := httpGet(client, "https://code.launchpad.net/"+m[2]+m[3]+"/.bzr/branch-format")
+		rc, err := httpGet(ctx, client, "https://code.launchpad.net/"+m[2]+m[3]+"/.bzr/branch-format")
 		switch err {
 		case nil:
 			// The structure of the import path is launchpad.net/{project}/{series}/{dir}. 
@@ -57,27 +55,32 @@ func getLaunchpadDoc(client *http.Client, m []string, savedEtag string) (*Packag
 	repo := m[1]
 	dir := normalizeDir(m[5])
 
-	p, etag, err := httpGetBytesCompare(client, "https://bazaar.launchpad.net/+branch/"+repo+"/tarball", savedEtag)
+	p, etag, err := httpGetBytesCompare(ctx, client, "https://bazaar.launchpad.net/+branch/"+repo+"/tarball", savedEtag)
 	if err != nil {
 		return nil, err
 	}
 
-	gzr, err := gzip.NewReader(bytes.NewReader(p))
+	ar, err := newArchiveReader(bytes.NewReader(p), importPath)
 	if err != nil {
 		return nil, err
 	}
-	defer gzr.Close()
-
-	tr := tar.NewReader(gzr)
 
 	inTree := false
 	prefix := "+branch/" + repo + "/"
 	var files []*source
+	goMods := make(map[string]*source)
 	for {
-		hdr, err := tr.Next()
+		hdr, err := ar.next()
 		if err == io.EOF {
 			break
 		}
+		if isLimitError(err) {
+			if !inTree {
+				return nil, err
+			}
+			files = append(files, &source{err: err})
+			break
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -85,13 +88,31 @@ func getLaunchpadDoc(client *http.Client, m []string, savedEtag string) (*Packag
 			continue
 		}
 		name := hdr.Name[len(prefix):]
+		if path.Base(name) == "go.mod" {
+			b, err := ar.read(name)
+			if isLimitError(err) {
+				files = append(files, &source{err: err})
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			goMods[name] = &source{
+				browseURL: "http://bazaar.launchpad.net/+branch/" + repo + "/view/head:/" + name,
+				data:      b}
+			continue
+		}
 		if !isDocFile(name) ||
 			!strings.HasPrefix(name, dir) {
 			continue
 		}
 		inTree = true
 		if d, f := path.Split(hdr.Name[len(prefix):]); d == dir {
-			b, err := ioutil.ReadAll(tr)
+			b, err := ar.read(name)
+			if isLimitError(err) {
+				files = append(files, &source{name: f, err: err})
+				continue
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -106,5 +127,9 @@ func getLaunchpadDoc(client *http.Client, m []string, savedEtag string) (*Packag
 		return nil, ErrPackageNotFound
 	}
 
+	if src := nearestGoMod(dir, goMods); src != nil {
+		files = append(files, src)
+	}
+
 	return buildDoc(importPath, projectRoot, projectName, projectURL, etag, "#L%d", files)
 }
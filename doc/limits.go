@@ -0,0 +1,197 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Limits bounds the resources used when fetching a package. When a file or
+// the number of files exceeds a limit, the documentation is built from the
+// files read before the limit was reached and the limit is reported in the
+// Errors field of the package.
+type Limits struct {
+	// Maximum number of bytes read in a response, including archives.
+	MaxArchiveBytes int64
+
+	// Maximum size of a source file. Larger files are omitted.
+	MaxFileBytes int64
+
+	// Maximum number of files read for a package.
+	MaxFiles int
+
+	// Maximum ratio of decompressed to compressed bytes in an archive.
+	MaxRatio int64
+}
+
+var limits = Limits{
+	MaxArchiveBytes: 64 << 20,
+	MaxFileBytes:    2 << 20,
+	MaxFiles:        1000,
+	MaxRatio:        100,
+}
+
+// SetLimits sets the limits used by Get. Fields with the value zero are not
+// changed. SetLimits must be called before calling Get.
+func SetLimits(l Limits) {
+	if l.MaxArchiveBytes > 0 {
+		limits.MaxArchiveBytes = l.MaxArchiveBytes
+	}
+	if l.MaxFileBytes > 0 {
+		limits.MaxFileBytes = l.MaxFileBytes
+	}
+	if l.MaxFiles > 0 {
+		limits.MaxFiles = l.MaxFiles
+	}
+	if l.MaxRatio > 0 {
+		limits.MaxRatio = l.MaxRatio
+	}
+}
+
+// LimitError is returned when a fetch exceeds a limit.
+type LimitError struct {
+	// Name of the limit: "archive size", "file size", "file count" or
+	// "compression ratio".
+	Limit string
+
+	// Name of the file or URL that exceeded the limit.
+	Name string
+
+	Max int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s limit of %d exceeded", e.Name, e.Limit, e.Max)
+}
+
+// readLimited reads r to EOF. A *LimitError is returned if r has more than
+// max bytes.
+func readLimited(r io.Reader, max int64, limit, name string) ([]byte, error) {
+	p, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(p)) > max {
+		return nil, &LimitError{Limit: limit, Name: name, Max: max}
+	}
+	return p, nil
+}
+
+// minRatioBytes is the number of decompressed bytes read from an archive
+// before the compression ratio is checked.
+const minRatioBytes = 1 << 20
+
+type countingReader struct {
+	r     io.Reader
+	n     int64
+	check func() error
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if err := r.check(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if cerr := r.check(); cerr != nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// archiveReader reads the files in a gzipped tar archive within the limits.
+type archiveReader struct {
+	name       string
+	compressed *countingReader
+	expanded   *countingReader
+	tr         *tar.Reader
+	nfiles     int
+
+	// The archive limit that was exceeded.
+	err error
+
+	// Set when reading is stopped by a limit.
+	stopped bool
+}
+
+func newArchiveReader(r io.Reader, name string) (*archiveReader, error) {
+	a := &archiveReader{name: name}
+	a.compressed = &countingReader{r: r, check: a.check}
+	a.expanded = &countingReader{check: a.check}
+	gzr, err := gzip.NewReader(a.compressed)
+	if err != nil {
+		if a.err != nil {
+			return nil, a.err
+		}
+		return nil, err
+	}
+	a.expanded.r = gzr
+	a.tr = tar.NewReader(a.expanded)
+	return a, nil
+}
+
+func (a *archiveReader) check() error {
+	if a.err != nil {
+		return a.err
+	}
+	switch {
+	case a.compressed.n > limits.MaxArchiveBytes:
+		a.err = &LimitError{Limit: "archive size", Name: a.name, Max: limits.MaxArchiveBytes}
+	case a.expanded.n > minRatioBytes && a.expanded.n > limits.MaxRatio*a.compressed.n:
+		a.err = &LimitError{Limit: "compression ratio", Name: a.name, Max: limits.MaxRatio}
+	}
+	return a.err
+}
+
+// next advances to the next file in the archive. A *LimitError is returned
+// if the archive exceeds a limit. After a limit is exceeded, next returns
+// io.EOF.
+func (a *archiveReader) next() (*tar.Header, error) {
+	if a.stopped {
+		return nil, io.EOF
+	}
+	hdr, err := a.tr.Next()
+	if err != nil && a.err != nil {
+		a.stopped = true
+		return nil, a.err
+	}
+	return hdr, err
+}
+
+// read reads the current file in the archive. A *LimitError is returned if
+// the file or the archive exceeds a limit.
+func (a *archiveReader) read(name string) ([]byte, error) {
+	a.nfiles++
+	if a.nfiles > limits.MaxFiles {
+		a.stopped = true
+		return nil, &LimitError{Limit: "file count", Name: a.name, Max: int64(limits.MaxFiles)}
+	}
+	p, err := readLimited(a.tr, limits.MaxFileBytes, "file size", name)
+	if err != nil && a.err != nil {
+		a.stopped = true
+		return nil, a.err
+	}
+	return p, err
+}
+
+// isLimitError returns true if err is a *LimitError.
+func isLimitError(err error) bool {
+	_, ok := err.(*LimitError)
+	return ok
+}
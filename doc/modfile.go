@@ -0,0 +1,405 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Module is the information from the go.mod file for a package.
+type Module struct {
+	// Module path from the module directive.
+	Path string
+
+	// Version from the go directive.
+	GoVersion string
+
+	Require []ModuleRequire
+	Replace []ModuleReplace
+
+	// Retracted versions. Version ranges are formatted as "[low, high]".
+	Retract []string
+
+	// Directory of the package relative to the module root. Dir is "" for
+	// the package at the module root.
+	Dir string
+
+	// Name of the go.mod file relative to the package directory and the
+	// link to the start of the file on the project site.
+	File string
+	URL  string
+}
+
+// ModuleRequire is a requirement from a go.mod require directive.
+type ModuleRequire struct {
+	Path     string
+	Version  string
+	Indirect bool
+}
+
+// ModuleReplace is a replacement from a go.mod replace directive. OldVersion
+// is "" if the replacement applies to all versions. NewVersion is "" if New
+// is a file path.
+type ModuleReplace struct {
+	Old        string
+	OldVersion string
+	New        string
+	NewVersion string
+}
+
+// modError is an error at a line in a go.mod file.
+type modError struct {
+	line    int
+	message string
+}
+
+// modFields splits a go.mod line into fields. Quoted strings are unquoted.
+// The returned comment is the text following "//".
+func modFields(line string) (fields []string, comment string, err error) {
+	for {
+		line = strings.TrimLeft(line, " \t")
+		switch {
+		case line == "":
+			return fields, "", nil
+		case strings.HasPrefix(line, "//"):
+			return fields, strings.TrimSpace(line[2:]), nil
+		case line[0] == '"' || line[0] == '`':
+			q, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, "", err
+			}
+			s, _ := strconv.Unquote(q)
+			fields = append(fields, s)
+			line = line[len(q):]
+		default:
+			i := strings.IndexAny(line, " \t")
+			if i < 0 {
+				i = len(line)
+			}
+			if j := strings.Index(line[:i], "//"); j > 0 {
+				i = j
+			}
+			fields = append(fields, line[:i])
+			line = line[i:]
+		}
+	}
+}
+
+// parseModFile parses the go.mod file in data.
+func parseModFile(data []byte) (*Module, []modError) {
+	m := &Module{}
+	var errs []modError
+	block := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		lineNum := i + 1
+		fields, comment, err := modFields(line)
+		if err != nil {
+			errs = append(errs, modError{lineNum, err.Error()})
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		verb := block
+		if block == "" {
+			verb = fields[0]
+			fields = fields[1:]
+			if len(fields) == 1 && fields[0] == "(" {
+				block = verb
+				continue
+			}
+		} else if len(fields) == 1 && fields[0] == ")" {
+			block = ""
+			continue
+		}
+
+		bad := func(format string, args ...interface{}) {
+			errs = append(errs, modError{lineNum, fmt.Sprintf(format, args...)})
+		}
+
+		switch verb {
+		case "module":
+			if len(fields) != 1 {
+				bad("usage: module module/path")
+				continue
+			}
+			m.Path = fields[0]
+		case "go":
+			if len(fields) != 1 {
+				bad("usage: go 1.23")
+				continue
+			}
+			m.GoVersion = fields[0]
+		case "require":
+			if len(fields) != 2 {
+				bad("usage: require module/path v1.2.3")
+				continue
+			}
+			m.Require = append(m.Require, ModuleRequire{
+				Path:     fields[0],
+				Version:  fields[1],
+				Indirect: comment == "indirect" || strings.HasPrefix(comment, "indirect;"),
+			})
+		case "replace":
+			arrow := 2
+			if len(fields) >= 2 && fields[1] == "=>" {
+				arrow = 1
+			}
+			if len(fields) < arrow+2 || len(fields) > arrow+3 || fields[arrow] != "=>" {
+				bad("usage: replace module/path [v1.2.3] => other/module v1.4 | ../local/directory")
+				continue
+			}
+			r := ModuleReplace{Old: fields[0], New: fields[arrow+1]}
+			if arrow == 2 {
+				r.OldVersion = fields[1]
+			}
+			if len(fields) == arrow+3 {
+				r.NewVersion = fields[arrow+2]
+			}
+			m.Replace = append(m.Replace, r)
+		case "retract":
+			switch {
+			case len(fields) == 1:
+				m.Retract = append(m.Retract, fields[0])
+			case len(fields) == 2 && strings.HasPrefix(fields[0], "[") && strings.HasSuffix(fields[1], "]"):
+				m.Retract = append(m.Retract, fields[0]+" "+fields[1])
+			default:
+				bad("usage: retract v1.2.3 | [v1.2.3, v1.3.0]")
+			}
+		case "exclude", "toolchain", "godebug", "tool", "ignore":
+			// Not displayed.
+		default:
+			bad("unknown directive: %s", verb)
+		}
+	}
+	if block != "" {
+		errs = append(errs, modError{strings.Count(string(data), "\n") + 1, "unterminated " + block + " block"})
+	}
+	if m.Path == "" {
+		errs = append(errs, modError{1, "no module directive"})
+	}
+	return m, errs
+}
+
+// isGoModSource returns true if the source is the go.mod file for the
+// package. Fetchers add the nearest go.mod file to the package sources with a
+// name relative to the package directory, for example "../go.mod".
+func isGoModSource(name string) bool {
+	return name == "go.mod" || strings.HasSuffix(name, "/go.mod")
+}
+
+// goModPaths returns the paths of the go.mod files that can apply to the
+// package in the normalized directory dir, nearest first. The names are the
+// names of the files relative to dir.
+func goModPaths(dir string) (paths []string, names []string) {
+	dir = strings.TrimSuffix(dir, "/")
+	rel := ""
+	for {
+		paths = append(paths, path.Join(dir, "go.mod"))
+		names = append(names, rel+"go.mod")
+		if dir == "" || dir == "." {
+			return paths, names
+		}
+		dir = path.Dir(dir)
+		if dir == "." {
+			dir = ""
+		}
+		rel += "../"
+	}
+}
+
+// nearestGoMod returns the go.mod file nearest to the package in directory
+// dir. The candidates map is keyed by the path of the file in the repository.
+// The returned source is named relative to dir. Nil is returned if there is
+// no go.mod file for the package.
+func nearestGoMod(dir string, candidates map[string]*source) *source {
+	paths, names := goModPaths(dir)
+	for i, p := range paths {
+		if src := candidates[p]; src != nil {
+			src.name = names[i]
+			return src
+		}
+	}
+	return nil
+}
+
+// fetchGoMod fetches the go.mod file nearest to the package in directory dir.
+// The function urls returns the raw and browse URLs for a path in the
+// repository. The header is sent with the requests. Nil is returned if there
+// is no go.mod file. Errors other than ErrPackageNotFound are returned so
+// that a transient error does not select the go.mod file of a parent
+// directory. The candidates from dir up to the repository root are fetched
+// concurrently.
+func fetchGoMod(ctx context.Context, client *http.Client, dir string, header http.Header, urls func(p string) (rawURL, browseURL string)) (*source, error) {
+	paths, names := goModPaths(dir)
+	ctx, cancel := withTimeout(ctx, timeouts.Files)
+	defer cancel()
+	srcs := make([]*source, len(paths))
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for i, p := range paths {
+		rawURL, browseURL := urls(p)
+		srcs[i] = &source{name: names[i], browseURL: browseURL, rawURL: rawURL}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			srcs[i].data, errs[i] = httpGetBytesHeader(ctx, client, srcs[i].rawURL, header)
+		}(i)
+	}
+	wg.Wait()
+	for i := range paths {
+		if errs[i] == ErrPackageNotFound {
+			continue
+		}
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		return srcs[i], nil
+	}
+	return nil, nil
+}
+
+// module parses the go.mod source for the package.
+func (b *builder) module() {
+	var src *source
+	for name, s := range b.srcs {
+		if isGoModSource(name) {
+			src = s
+			break
+		}
+	}
+	if src == nil {
+		return
+	}
+
+	f := b.fset.AddFile(src.name, -1, len(src.data))
+	f.SetLinesForContent(src.data)
+	report := func(line int, format string, args ...interface{}) {
+		pos := token.NoPos
+		if line >= 1 && line <= f.LineCount() {
+			pos = f.LineStart(line)
+		}
+		b.pkg.Diagnostics = append(b.pkg.Diagnostics, Diagnostic{
+			Analyzer: "gomod",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf(format, args...),
+			File:     src.name,
+			Line:     line,
+			Column:   1,
+			URL:      b.printPos(pos),
+		})
+	}
+
+	m, errs := parseModFile(src.data)
+	for _, e := range errs {
+		report(e.line, "%s", e.message)
+	}
+
+	depth := strings.Count(src.name, "../")
+	parts := strings.Split(b.pkg.ImportPath, "/")
+	if depth < len(parts) {
+		m.Dir = strings.Join(parts[len(parts)-depth:], "/")
+	}
+	m.File = src.name
+	m.URL = b.printPos(f.LineStart(1))
+	b.pkg.Module = m
+}
+
+// modulePackagePath returns the import path of the package given by the
+// module path and the package directory in the module.
+func modulePackagePath(m *Module) string {
+	if m.Dir == "" {
+		return m.Path
+	}
+	return m.Path + "/" + m.Dir
+}
+
+// isMajorVersion returns true if s is a major version suffix element v2,
+// v3, ... of a module path.
+func isMajorVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' || s[1] == '0' {
+		return false
+	}
+	n, err := strconv.Atoi(s[1:])
+	return err == nil && n >= 2
+}
+
+// stripMajorVersion returns p without the major version suffix. The suffix
+// is a final /vN element or the .vN suffix of the package element of a
+// gopkg.in path.
+func stripMajorVersion(p string) string {
+	if strings.HasPrefix(p, "gopkg.in/") {
+		parts := strings.Split(p, "/")
+		for i := 1; i < len(parts) && i < 3; i++ {
+			j := strings.LastIndex(parts[i], ".v")
+			if j < 0 {
+				continue
+			}
+			if _, err := strconv.Atoi(parts[i][j+2:]); err == nil {
+				parts[i] = parts[i][:j]
+				return strings.Join(parts, "/")
+			}
+		}
+		return p
+	}
+	if i := strings.LastIndex(p, "/"); i >= 0 && isMajorVersion(p[i+1:]) {
+		return p[:i]
+	}
+	return p
+}
+
+// matchesModulePath returns true if importPath is the path of the package in
+// module m. The major version suffix of the module path is ignored because
+// the go.mod file of a major version is usually at the repository root and
+// the repository is fetched without the suffix.
+func matchesModulePath(m *Module, importPath string) bool {
+	if modulePackagePath(m) == importPath {
+		return true
+	}
+	p := stripMajorVersion(m.Path)
+	if m.Dir != "" {
+		p += "/" + m.Dir
+	}
+	return p == stripMajorVersion(importPath)
+}
+
+// checkModulePath reports a diagnostic if the package import path does not
+// agree with the module path. The check is made after the import path is
+// final; dynamic import paths are built from the repository path.
+func checkModulePath(pdoc *Package) {
+	m := pdoc.Module
+	if m == nil || m.Path == "" || matchesModulePath(m, pdoc.ImportPath) {
+		return
+	}
+	want := modulePackagePath(m)
+	d := Diagnostic{
+		Analyzer: "gomod",
+		Severity: SeverityWarning,
+		Message:  fmt.Sprintf("import path %s does not match module path %s; the package import path is %s", pdoc.ImportPath, m.Path, want),
+		File:     m.File,
+		Line:     1,
+		Column:   1,
+		URL:      m.URL,
+	}
+	pdoc.Diagnostics = append(pdoc.Diagnostics, d)
+}
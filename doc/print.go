@@ -37,6 +37,9 @@ func main() {
 	if len(os.Args) != 2 {
 		log.Fatal("Usage: go run print.go importPath")
 	}
+	if err := doc.LoadAPIHistory("../api"); err != nil {
+		log.Fatal(err)
+	}
 	dpkg, err := doc.Get(http.DefaultClient, os.Args[1], "")
 	if err != nil {
 		log.Fatal(err)
@@ -50,11 +53,22 @@ func main() {
 	fmt.Println("Name:        ", dpkg.Name)
 	fmt.Println("IsCmd:       ", dpkg.IsCmd)
 	fmt.Println("Synopsis:    ", dpkg.Synopsis)
+	fmt.Println("MinGoVersion:", dpkg.MinGoVersion)
 	fmt.Println("Doc:         ", indent(dpkg.Doc, 14))
 	fmt.Println("Errors:")
 	for _, s := range dpkg.Errors {
 		fmt.Println("    ", s)
 	}
+	if m := dpkg.Module; m != nil {
+		fmt.Println("Module:      ", m.Path, m.GoVersion, m.File)
+		for _, r := range m.Require {
+			fmt.Println("     require", r.Path, r.Version)
+		}
+	}
+	fmt.Println("Diagnostics:")
+	for _, d := range dpkg.Diagnostics {
+		fmt.Println("    ", &d)
+	}
 	fmt.Println("Files:")
 	for _, f := range dpkg.Files {
 		fmt.Println("    ", f)
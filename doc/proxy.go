@@ -15,46 +15,67 @@
 package doc
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"context"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
-func getProxyDoc(client *http.Client, importPath, projectRoot, projectName, projectURL, etag string) (*Package, error) {
+func getProxyDoc(ctx context.Context, client *http.Client, importPath, projectRoot, projectName, projectURL string, gosrc *goSource, etag string) (*Package, error) {
 
-	rc, err := httpGet(client, "http://go-get.danga.com/"+importPath)
+	rc, err := httpGet(ctx, client, "http://go-get.danga.com/"+importPath)
 	if err != nil {
 		return nil, err
 	}
 	defer rc.Close()
-	gzr, err := gzip.NewReader(rc)
+	ar, err := newArchiveReader(rc, importPath)
 	if err != nil {
 		return nil, err
 	}
-	defer gzr.Close()
-	tr := tar.NewReader(gzr)
+	dir := strings.TrimPrefix(importPath[len(projectRoot):], "/")
+	lineFmt := "#L%d"
 	var files []*source
 	for {
-		hdr, err := tr.Next()
+		hdr, err := ar.next()
 		if err == io.EOF {
 			break
 		}
+		if isLimitError(err) {
+			files = append(files, &source{err: err})
+			break
+		}
 		if err != nil {
 			return nil, err
 		}
-		if !isDocFile(hdr.Name) {
+		if !isDocFile(hdr.Name) && hdr.Name != "go.mod" {
+			continue
+		}
+		b, err := ar.read(hdr.Name)
+		if isLimitError(err) {
+			files = append(files, &source{name: hdr.Name, err: err})
 			continue
 		}
-		b, err := ioutil.ReadAll(tr)
 		if err != nil {
 			return nil, err
 		}
+		browseURL := "/-/src/" + importPath + "/" + hdr.Name
+		if gosrc != nil && gosrc.file != "" {
+			// Link to the project's source browser.
+			browseURL, lineFmt = gosrc.fileURL(dir, hdr.Name)
+		}
 		files = append(files, &source{
 			name:      hdr.Name,
-			browseURL: "http://gosourcefile.appspot.com/" + importPath + "/" + hdr.Name,
+			browseURL: browseURL,
 			data:      b})
 	}
-	return buildDoc(importPath, projectRoot, projectName, projectURL, etag, "#L%d", files)
+	pdoc, err := buildDoc(importPath, projectRoot, projectName, projectURL, etag, lineFmt, files)
+	if err != nil || (gosrc != nil && gosrc.file != "") {
+		return pdoc, err
+	}
+	// The declarations link to the source files on this site. Link the
+	// files to the project site.
+	for _, f := range pdoc.Files {
+		f.URL = projectURL
+	}
+	return pdoc, nil
 }
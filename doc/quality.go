@@ -0,0 +1,143 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"go/doc"
+	"strings"
+)
+
+// Quality holds documentation quality metrics for a package.
+type Quality struct {
+	// Score from 0 to 100.
+	Score int
+
+	// Number of exported identifiers and the number of exported identifiers
+	// with a doc comment.
+	Exported   int
+	Documented int
+
+	// Names of identifiers with a doc comment that does not start with the
+	// identifier name.
+	BadComments []string
+
+	// Number of exported types and the number of exported types with at
+	// least one example.
+	Types        int
+	TypesExample int
+
+	// Total number of examples in the package.
+	Examples int
+
+	// True if the package has a package doc comment.
+	PackageDoc bool
+}
+
+// commentStartsWithName returns true if the doc comment s starts with name,
+// optionally preceded by an article.
+func commentStartsWithName(s, name string) bool {
+	for _, prefix := range []string{"", "A ", "An ", "The "} {
+		if strings.HasPrefix(s, prefix+name) {
+			rest := s[len(prefix+name):]
+			return rest == "" || strings.IndexAny(rest[:1], " \t\n'.,:;") == 0
+		}
+	}
+	return false
+}
+
+// addIdentifier adds an exported identifier to the metrics. The label is the
+// name reported in BadComments.
+func (q *Quality) addIdentifier(name, label, comment string) {
+	q.Exported++
+	if comment == "" {
+		return
+	}
+	q.Documented++
+	if !commentStartsWithName(comment, name) {
+		q.BadComments = append(q.BadComments, label)
+	}
+}
+
+func (q *Quality) addValues(values []*doc.Value) {
+	for _, v := range values {
+		for _, name := range v.Names {
+			if !startsWithUppercase(name) {
+				continue
+			}
+			// A comment on a group documents the group and does not need to
+			// start with the name of an identifier in the group.
+			if len(v.Names) > 1 && v.Doc != "" {
+				q.Exported++
+				q.Documented++
+			} else {
+				q.addIdentifier(name, name, v.Doc)
+			}
+		}
+	}
+}
+
+func (q *Quality) addFuncs(funcs []*doc.Func, prefix string) {
+	for _, f := range funcs {
+		q.addIdentifier(f.Name, prefix+f.Name, f.Doc)
+	}
+}
+
+// quality computes the documentation quality metrics for a package.
+func (b *builder) quality(pdoc *doc.Package) *Quality {
+	q := &Quality{PackageDoc: pdoc.Doc != ""}
+	q.addValues(pdoc.Consts)
+	q.addValues(pdoc.Vars)
+	q.addFuncs(pdoc.Funcs, "")
+	for _, t := range pdoc.Types {
+		q.Types++
+		q.addIdentifier(t.Name, t.Name, t.Doc)
+		q.addValues(t.Consts)
+		q.addValues(t.Vars)
+		q.addFuncs(t.Funcs, "")
+		q.addFuncs(t.Methods, t.Name+".")
+	}
+
+	q.Examples = len(b.examples)
+	for _, t := range b.pkg.Types {
+		if len(t.Examples) > 0 {
+			q.TypesExample++
+		}
+	}
+
+	// The score weights documented identifiers at 60%, comments starting
+	// with the identifier name at 20%, the package comment at 10% and types
+	// with examples at 10%.
+	score := 0.0
+	if q.Exported > 0 {
+		score += 60 * float64(q.Documented) / float64(q.Exported)
+	} else {
+		score += 60
+	}
+	if q.Documented > 0 {
+		score += 20 * float64(q.Documented-len(q.BadComments)) / float64(q.Documented)
+	} else if q.Exported == 0 {
+		score += 20
+	}
+	if q.PackageDoc {
+		score += 10
+	}
+	if q.Types > 0 {
+		score += 10 * float64(q.TypesExample) / float64(q.Types)
+	} else {
+		score += 10
+	}
+	q.Score = int(score + 0.5)
+	return q
+}
@@ -0,0 +1,75 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Timeouts bounds the time spent in the phases of fetching a package.
+type Timeouts struct {
+	// Time to fetch the go-import meta tags for an import path.
+	Meta time.Duration
+
+	// Time for each request for a repository listing, API resource or
+	// archive.
+	Listing time.Duration
+
+	// Time to fetch all of the source files in a package.
+	Files time.Duration
+}
+
+var timeouts = Timeouts{
+	Meta:    10 * time.Second,
+	Listing: 30 * time.Second,
+	Files:   60 * time.Second,
+}
+
+// SetTimeouts sets the timeouts used by GetContext. Fields with the value
+// zero are not changed. SetTimeouts must be called before calling Get.
+func SetTimeouts(t Timeouts) {
+	if t.Meta > 0 {
+		timeouts.Meta = t.Meta
+	}
+	if t.Listing > 0 {
+		timeouts.Listing = t.Listing
+	}
+	if t.Files > 0 {
+		timeouts.Files = t.Files
+	}
+}
+
+// withTimeout returns a copy of ctx with the timeout d.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// cancelReadCloser cancels the context of a response when the response body
+// is closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (rc *cancelReadCloser) Close() error {
+	err := rc.ReadCloser.Close()
+	rc.cancel()
+	return err
+}
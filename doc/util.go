@@ -1,13 +1,13 @@
 package doc
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -26,21 +26,25 @@ func isDocFile(p string) bool {
 	return strings.HasSuffix(n, ".go") && len(n) > 0 && n[0] != '_' && n[0] != '.'
 }
 
-type GetError struct {
-	Host string
-	err  error
-}
-
-func (e GetError) Error() string {
-	return e.err.Error()
-}
-
-// fetchFiles fetches the source files specified by the rawURL field in parallel.
-func fetchFiles(client *http.Client, files []*source, header http.Header) error {
-	ch := make(chan error)
+// fetchFiles fetches the source files specified by the rawURL field in
+// parallel. Files exceeding the limits are not fetched and the err field of
+// the files is set. The requests in progress are stopped on the first error.
+func fetchFiles(ctx context.Context, client *http.Client, files []*source, header http.Header) error {
+	if len(files) > limits.MaxFiles {
+		err := &LimitError{Limit: "file count", Name: files[limits.MaxFiles].name, Max: int64(limits.MaxFiles)}
+		for _, f := range files[limits.MaxFiles:] {
+			f.err = err
+		}
+		files = files[:limits.MaxFiles]
+	}
+	ctx, cancel := withTimeout(ctx, timeouts.Files)
+	defer cancel()
+	// The channel is buffered so that the goroutines do not block after
+	// fetchFiles returns on an error.
+	ch := make(chan error, len(files))
 	for i := range files {
 		go func(i int) {
-			req, err := http.NewRequest("GET", files[i].rawURL, nil)
+			req, err := http.NewRequestWithContext(ctx, "GET", files[i].rawURL, nil)
 			if err != nil {
 				ch <- err
 				return
@@ -50,16 +54,22 @@ func fetchFiles(client *http.Client, files []*source, header http.Header) error
 			}
 			resp, err := client.Do(req)
 			if err != nil {
-				ch <- GetError{req.URL.Host, err}
+				ch <- fetchError(req.URL.Host, err)
 				return
 			}
 			if resp.StatusCode != 200 {
-				ch <- GetError{req.URL.Host, fmt.Errorf("get %s -> %d", req.URL, resp.StatusCode)}
+				resp.Body.Close()
+				ch <- responseError(resp)
 				return
 			}
-			files[i].data, err = ioutil.ReadAll(resp.Body)
+			files[i].data, err = readLimited(resp.Body, limits.MaxFileBytes, "file size", files[i].name)
+			resp.Body.Close()
+			if isLimitError(err) {
+				files[i].err = err
+				err = nil
+			}
 			if err != nil {
-				ch <- GetError{req.URL.Host, err}
+				ch <- fetchError(req.URL.Host, err)
 				return
 			}
 			ch <- nil
@@ -75,35 +85,55 @@ func fetchFiles(client *http.Client, files []*source, header http.Header) error
 
 // httpGet gets the specified resource. ErrPackageNotFound is returned if the
 // server responds with status 404.
-func httpGet(client *http.Client, url string) (io.ReadCloser, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func httpGet(ctx context.Context, client *http.Client, url string) (io.ReadCloser, error) {
+	return httpGetHeader(ctx, client, url, nil)
+}
+
+// httpGetHeader gets the specified resource with the given request headers.
+// ErrPackageNotFound is returned if the server responds with status 404.
+func httpGetHeader(ctx context.Context, client *http.Client, url string, header http.Header) (io.ReadCloser, error) {
+	ctx, cancel := withTimeout(ctx, timeouts.Listing)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, GetError{req.URL.Host, err}
+		cancel()
+		return nil, fetchError(req.URL.Host, err)
 	}
 	if resp.StatusCode == 200 {
-		return resp.Body, nil
+		return &cancelReadCloser{resp.Body, cancel}, nil
 	}
 	resp.Body.Close()
+	cancel()
 	if resp.StatusCode == 404 {
-		err = ErrPackageNotFound
+		err = notFoundError(resp)
 	} else {
-		err = GetError{req.URL.Host, fmt.Errorf("get %s -> %d", url, resp.StatusCode)}
+		err = responseError(resp)
 	}
 	return nil, err
 }
 
 // httpGet gets the specified resource. ErrPackageNotFound is returned if the
 // server responds with status 404.
-func httpGetBytes(client *http.Client, url string) ([]byte, error) {
-	rc, err := httpGet(client, url)
+func httpGetBytes(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	return httpGetBytesHeader(ctx, client, url, nil)
+}
+
+// httpGetBytesHeader gets the specified resource with the given request
+// headers. ErrPackageNotFound is returned if the server responds with status
+// 404.
+func httpGetBytesHeader(ctx context.Context, client *http.Client, url string, header http.Header) ([]byte, error) {
+	rc, err := httpGetHeader(ctx, client, url, header)
 	if err != nil {
 		return nil, err
 	}
-	p, err := ioutil.ReadAll(rc)
+	p, err := readLimited(rc, limits.MaxArchiveBytes, "archive size", url)
 	rc.Close()
 	return p, err
 }
@@ -111,15 +141,17 @@ func httpGetBytes(client *http.Client, url string) ([]byte, error) {
 // httpGetBytesNoneMatch conditionally gets the specified resource. If a 304 status
 // is returned, then the function returns ErrPackageNotModified. If a 404
 // status is returned, then the function returns ErrPackageNotFound. 
-func httpGetBytesNoneMatch(client *http.Client, url string, etag string) ([]byte, string, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func httpGetBytesNoneMatch(ctx context.Context, client *http.Client, url string, etag string) ([]byte, string, error) {
+	ctx, cancel := withTimeout(ctx, timeouts.Listing)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, "", err
 	}
 	req.Header.Set("If-None-Match", `"`+etag+`"`)
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, "", GetError{req.URL.Host, err}
+		return nil, "", fetchError(req.URL.Host, err)
 	}
 	defer resp.Body.Close()
 
@@ -132,14 +164,14 @@ func httpGetBytesNoneMatch(client *http.Client, url string, etag string) ([]byte
 
 	switch resp.StatusCode {
 	case 200:
-		p, err := ioutil.ReadAll(resp.Body)
+		p, err := readLimited(resp.Body, limits.MaxArchiveBytes, "archive size", url)
 		return p, etag, err
 	case 404:
-		return nil, "", ErrPackageNotFound
+		return nil, "", notFoundError(resp)
 	case 304:
 		return nil, "", ErrPackageNotModified
 	default:
-		return nil, "", GetError{req.URL.Host, fmt.Errorf("get %s -> %d", url, resp.StatusCode)}
+		return nil, "", responseError(resp)
 	}
 	panic("unreachable")
 }
@@ -147,8 +179,8 @@ func httpGetBytesNoneMatch(client *http.Client, url string, etag string) ([]byte
 // httpGet gets the specified resource. ErrPackageNotFound is returned if the
 // server responds with status 404. ErrPackageNotModified is returned if the
 // hash of the resource equals savedEtag.
-func httpGetBytesCompare(client *http.Client, url string, savedEtag string) ([]byte, string, error) {
-	p, err := httpGetBytes(client, url)
+func httpGetBytesCompare(ctx context.Context, client *http.Client, url string, savedEtag string) ([]byte, string, error) {
+	p, err := httpGetBytes(ctx, client, url)
 	if err != nil {
 		return nil, "", err
 	}
@@ -160,3 +192,18 @@ func httpGetBytesCompare(client *http.Client, url string, savedEtag string) ([]b
 	}
 	return p, etag, err
 }
+
+// filesEtag returns a hash of the names and contents of files for use as an
+// etag when the server does not provide one for the package.
+func filesEtag(files []*source) string {
+	h := md5.New()
+	for _, f := range files {
+		io.WriteString(h, f.name)
+		if f.err != nil {
+			io.WriteString(h, "\x00"+f.err.Error())
+		}
+		io.WriteString(h, "\x00"+strconv.Itoa(len(f.data))+"\x00")
+		h.Write(f.data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,241 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// VanityRule maps import paths to a repository. Rules are loaded from a
+// configuration file with LoadVanityRules and are checked before the
+// statically known services.
+//
+// The URL templates are expanded with the variables {importPath},
+// {projectRoot}, {dir} (the package directory relative to the project root),
+// {file}, {path} (dir and file joined) and {vcs}. Named groups in Pattern are
+// also available as variables.
+//
+// If RawURL is empty, the expanded Repo URL must name a repository on one of
+// the statically known services, for example https://github.com/user/repo.
+// Otherwise, the package files are found by scraping the page at RawURL with
+// an empty {file} for links to Go files.
+type VanityRule struct {
+	// Project root prefix of the import paths handled by the rule.
+	Prefix string
+
+	// Regular expression matching the project root at the start of the
+	// import path. Pattern is used if Prefix is empty.
+	Pattern string
+
+	// Version control system: "git", "hg", "svn" or "bzr".
+	VCS string
+
+	// URL of the repository. Repo is also used as the project URL.
+	Repo string
+
+	// Template for the URL of a file on the project site and the format for
+	// linking to a line in the file, for example "#L%d".
+	BrowseURL string
+	LineFmt   string
+
+	// Template for the URL of the raw file contents.
+	RawURL string
+
+	re *regexp.Regexp
+}
+
+var vanityRules []*VanityRule
+
+var (
+	vanityVarPattern  = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+	vanityFilePattern = regexp.MustCompile(`href="(?:[^"]*/)?([A-Za-z0-9_.\-]+\.go)"`)
+)
+
+// LoadVanityRules loads the JSON encoded list of rules in the file filename.
+func LoadVanityRules(filename string) error {
+	p, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var rules []*VanityRule
+	if err := json.Unmarshal(p, &rules); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	for i, r := range rules {
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("%s: rule %d: %v", filename, i, err)
+		}
+	}
+	vanityRules = rules
+	return nil
+}
+
+func (r *VanityRule) compile() error {
+	switch r.VCS {
+	case "git", "hg", "svn", "bzr":
+	default:
+		return fmt.Errorf("unknown VCS %q", r.VCS)
+	}
+	if r.Repo == "" {
+		return errors.New("repository URL not set")
+	}
+	if r.RawURL != "" && r.BrowseURL == "" {
+		return errors.New("browse URL not set")
+	}
+	if r.Prefix == "" {
+		if r.Pattern == "" {
+			return errors.New("prefix or pattern not set")
+		}
+		re, err := regexp.Compile(`^(?:` + r.Pattern + `)`)
+		if err != nil {
+			return err
+		}
+		r.re = re
+	}
+	return nil
+}
+
+// match returns the template variables for importPath or nil if the rule
+// does not match importPath.
+func (r *VanityRule) match(importPath string) map[string]string {
+	vars := map[string]string{"importPath": importPath, "vcs": r.VCS}
+	projectRoot := r.Prefix
+	if r.re != nil {
+		m := r.re.FindStringSubmatch(importPath)
+		if m == nil {
+			return nil
+		}
+		projectRoot = m[0]
+		for i, name := range r.re.SubexpNames() {
+			if name != "" {
+				vars[name] = m[i]
+			}
+		}
+	}
+	if !strings.HasPrefix(importPath, projectRoot) {
+		return nil
+	}
+	dir := importPath[len(projectRoot):]
+	if dir != "" && dir[0] != '/' {
+		return nil
+	}
+	vars["projectRoot"] = projectRoot
+	vars["dir"] = strings.TrimPrefix(dir, "/")
+	return vars
+}
+
+// expand replaces the variables in template s.
+func expand(s string, vars map[string]string) string {
+	return vanityVarPattern.ReplaceAllStringFunc(s, func(v string) string {
+		if value, ok := vars[v[1:len(v)-1]]; ok {
+			return value
+		}
+		return v
+	})
+}
+
+// getVanity gets a document using the vanity rules. getVanity returns
+// errNoMatch if no rule matches the import path.
+func getVanity(ctx context.Context, client *http.Client, importPath string, etag string) (*Package, error) {
+	for _, r := range vanityRules {
+		vars := r.match(importPath)
+		if vars == nil {
+			continue
+		}
+		projectRoot := vars["projectRoot"]
+		_, projectName := path.Split(projectRoot)
+		projectURL := expand(r.Repo, vars)
+		if r.RawURL == "" {
+			return getRepoDoc(ctx, client, importPath, projectURL, projectRoot, projectName, projectURL, nil, etag)
+		}
+		return r.getDoc(ctx, client, vars, projectName, projectURL, etag)
+	}
+	return nil, errNoMatch
+}
+
+func (r *VanityRule) getDoc(ctx context.Context, client *http.Client, vars map[string]string, projectName, projectURL, savedEtag string) (*Package, error) {
+	urls := func(file string) (rawURL, browseURL string) {
+		vars["file"] = file
+		vars["path"] = path.Join(vars["dir"], file)
+		if file == "" && vars["path"] != "" {
+			vars["path"] += "/"
+		}
+		return expand(r.RawURL, vars), expand(r.BrowseURL, vars)
+	}
+
+	// Scrape the directory listing to find links to individual Go files.
+	dirURL, _ := urls("")
+	p, err := httpGetBytes(ctx, client, dirURL)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var files []*source
+	for _, m := range vanityFilePattern.FindAllSubmatch(p, -1) {
+		fname := string(m[1])
+		if !isDocFile(fname) || seen[fname] {
+			continue
+		}
+		seen[fname] = true
+		rawURL, browseURL := urls(fname)
+		files = append(files, &source{
+			name:      fname,
+			browseURL: browseURL,
+			rawURL:    rawURL,
+		})
+	}
+
+	if err := fetchFiles(ctx, client, files, nil); err != nil {
+		return nil, err
+	}
+
+	src, err := fetchGoMod(ctx, client, vars["dir"], nil, func(p string) (string, string) {
+		vars["file"] = "go.mod"
+		vars["path"] = p
+		vars["dir"] = path.Dir(p)
+		if vars["dir"] == "." {
+			vars["dir"] = ""
+		}
+		return expand(r.RawURL, vars), expand(r.BrowseURL, vars)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if src != nil {
+		files = append(files, src)
+	}
+
+	// The listing does not change when a file is edited, so the etag is
+	// computed from the files.
+	etag := filesEtag(files)
+	if etag == savedEtag {
+		return nil, ErrPackageNotModified
+	}
+
+	lineFmt := r.LineFmt
+	if lineFmt == "" {
+		lineFmt = "#L%d"
+	}
+	return buildDoc(vars["importPath"], vars["projectRoot"], projectName, projectURL, etag, lineFmt, files)
+}
@@ -0,0 +1,147 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package doc
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+)
+
+var errMissingSource = errors.New("source not available")
+
+// sourceImporter type checks imported packages from the source files stored
+// in package documentation.
+type sourceImporter struct {
+	fset       *token.FileSet
+	getPackage func(importPath string) (*Package, error)
+	pkgs       map[string]*types.Package
+
+	// Set to true when the source for an imported package is not available.
+	missing bool
+}
+
+func (si *sourceImporter) Import(importPath string) (*types.Package, error) {
+	if importPath == "unsafe" {
+		return types.Unsafe, nil
+	}
+	if pkg, ok := si.pkgs[importPath]; ok {
+		if pkg == nil {
+			// Import cycle or package not available.
+			si.missing = true
+			return nil, errMissingSource
+		}
+		return pkg, nil
+	}
+	si.pkgs[importPath] = nil
+
+	pdoc, err := si.getPackage(importPath)
+	if err != nil || pdoc == nil || pdoc.Name == "" || pdoc.IsCmd {
+		si.missing = true
+		return nil, errMissingSource
+	}
+	var files []*ast.File
+	for _, f := range pdoc.Files {
+		if f.Source.Text == "" {
+			// Documentation stored before source files were kept.
+			si.missing = true
+			return nil, errMissingSource
+		}
+		file, err := parser.ParseFile(si.fset, importPath+"/"+f.Name, f.Source.Text, 0)
+		if err != nil {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	// Errors in imported packages are ignored. The checker returns a
+	// usable package for code with errors.
+	conf := types.Config{
+		Importer:    si,
+		FakeImportC: true,
+		Error:       func(error) {},
+	}
+	pkg, _ := conf.Check(importPath, si.fset, files, nil)
+	si.pkgs[importPath] = pkg
+	return pkg, nil
+}
+
+// VerifyExamples type checks the runnable examples in pdoc against the source
+// of the package and the stored source of the packages imported by the
+// examples. The function getPackage returns the stored documentation for an
+// import path.
+//
+// Type errors are recorded in the Errors field of the example. An example is
+// not verified when the source for an imported package is not available.
+// Examples in the package's internal test files are not runnable and are not
+// verified. VerifyExamples returns the number of broken examples.
+func VerifyExamples(pdoc *Package, getPackage func(importPath string) (*Package, error)) int {
+	si := &sourceImporter{
+		fset: token.NewFileSet(),
+		getPackage: func(importPath string) (*Package, error) {
+			if importPath == pdoc.ImportPath {
+				return pdoc, nil
+			}
+			return getPackage(importPath)
+		},
+		pkgs: make(map[string]*types.Package),
+	}
+
+	broken := 0
+	verify := func(examples []Example) {
+		for i := range examples {
+			e := &examples[i]
+			e.Errors = nil
+			if e.Play == "" {
+				continue
+			}
+			file, err := parser.ParseFile(si.fset, "example_"+e.Name+".go", e.Play, 0)
+			if err != nil {
+				e.Errors = append(e.Errors, err.Error())
+				broken++
+				continue
+			}
+			var errs []string
+			si.missing = false
+			conf := types.Config{
+				Importer: si,
+				Error:    func(err error) { errs = append(errs, err.Error()) },
+			}
+			conf.Check("main", si.fset, []*ast.File{file}, nil)
+			if si.missing || len(errs) == 0 {
+				continue
+			}
+			e.Errors = errs
+			broken++
+		}
+	}
+
+	verify(pdoc.Examples)
+	for _, f := range pdoc.Funcs {
+		verify(f.Examples)
+	}
+	for _, t := range pdoc.Types {
+		verify(t.Examples)
+		for _, f := range t.Funcs {
+			verify(f.Examples)
+		}
+		for _, f := range t.Methods {
+			verify(f.Examples)
+		}
+	}
+	return broken
+}
@@ -15,9 +15,11 @@
 package doc
 
 import (
-	"fmt"
 	"go/ast"
 	"go/token"
+	"path"
+	"strconv"
+	"strings"
 )
 
 // This list of deprecated exports is used to find code that has not been
@@ -42,12 +44,19 @@ var deprecatedExports = map[string][]string{
 	"unicode/utf8":  []string{"NewString"},
 }
 
-type vetVisitor struct {
+func init() {
+	RegisterAnalyzer(&Analyzer{Name: "deprecated", Run: checkDeprecated})
+	RegisterAnalyzer(&Analyzer{Name: "imports", Run: checkImports})
+	RegisterAnalyzer(&Analyzer{Name: "doccomment", Run: checkDocComments})
+	RegisterAnalyzer(&Analyzer{Name: "shadow", Run: checkShadowedNames})
+}
+
+type deprecatedVisitor struct {
+	pass        *Pass
 	importPaths map[string]string
-	errors      map[string]token.Pos
 }
 
-func (v *vetVisitor) Visit(n ast.Node) ast.Visitor {
+func (v *deprecatedVisitor) Visit(n ast.Node) ast.Visitor {
 	sel, ok := n.(*ast.SelectorExpr)
 	if !ok {
 		return v
@@ -59,34 +68,163 @@ func (v *vetVisitor) Visit(n ast.Node) ast.Visitor {
 	importPath := v.importPaths[id.Name]
 	for _, name := range deprecatedExports[importPath] {
 		if name == sel.Sel.Name {
-			v.errors[fmt.Sprintf("%q.%s not found", importPath, sel.Sel.Name)] = n.Pos()
+			v.pass.Reportf(n.Pos(), SeverityError, "%q.%s not found", importPath, sel.Sel.Name)
 			return v
 		}
 	}
 	return v
 }
 
-func (b *builder) vetPackage() {
-	errors := make(map[string]token.Pos)
-	for fname, file := range b.ast.Files {
+// checkDeprecated finds uses of standard package exports removed in Go 1.
+func checkDeprecated(pass *Pass) {
+	for _, fname := range pass.FileNames() {
+		ast.Walk(&deprecatedVisitor{pass: pass, importPaths: pass.FileImportPaths(fname)}, pass.Package.Files[fname])
+	}
+}
+
+// checkImports finds imports that cannot be resolved by "go get". The
+// standard packages are found in the API history, so the check is skipped
+// if the history is not loaded.
+func checkImports(pass *Pass) {
+	if apiPackages == nil {
+		return
+	}
+	for _, fname := range pass.FileNames() {
+		for _, is := range pass.Package.Files[fname].Imports {
+			importPath, _ := strconv.Unquote(is.Path.Value)
+			if !isStandardPackage(importPath) &&
+				!ValidRemotePath(importPath) &&
+				importPath != "C" &&
+				!strings.HasPrefix(importPath, "appengine") {
+				pass.Reportf(is.Pos(), SeverityWarning, "Cannot import %q", importPath)
+			}
+		}
+	}
+}
+
+// maxDocCommentDiagnostics is the number of undocumented exports reported
+// individually. The remaining exports are reported in one diagnostic so that
+// generated packages do not bloat the stored documentation.
+const maxDocCommentDiagnostics = 10
 
-		/*
-			for _, is := range file.Imports {
-				importPath, _ := strconv.Unquote(is.Path.Value)
-				if !StandardPackages[importPath] &&
-					!ValidRemotePath(importPath) &&
-					importPath != "C" &&
-					!strings.HasPrefix(importPath, "appengine") {
-					errors[fmt.Sprintf("Cannot import %q", importPath)] = is.Pos()
+// checkDocComments finds exported top-level declarations without a doc
+// comment.
+func checkDocComments(pass *Pass) {
+	if pass.Package.Name == "main" || pass.Package.Name == "documentation" {
+		return
+	}
+	n := 0
+	var morePos token.Pos
+	report := func(name *ast.Ident, kind string) {
+		if !ast.IsExported(name.Name) {
+			return
+		}
+		n++
+		switch {
+		case n <= maxDocCommentDiagnostics:
+			pass.Reportf(name.Pos(), SeverityInfo, "exported %s %s should have a comment", kind, name.Name)
+		case n == maxDocCommentDiagnostics+1:
+			morePos = name.Pos()
+		}
+	}
+	for _, fname := range pass.FileNames() {
+		for _, decl := range pass.Package.Files[fname].Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Doc != nil {
+					continue
+				}
+				if decl.Recv == nil {
+					report(decl.Name, "func")
+				} else if ast.IsExported(recvTypeName(decl.Recv)) {
+					report(decl.Name, "method")
+				}
+			case *ast.GenDecl:
+				if decl.Doc != nil && (decl.Lparen == 0 || decl.Tok != token.TYPE) {
+					// A doc comment on a group of constants or variables
+					// documents all of the specs in the group.
+					continue
+				}
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						if spec.Doc == nil {
+							report(spec.Name, "type")
+						}
+					case *ast.ValueSpec:
+						if spec.Doc == nil && spec.Comment == nil {
+							for _, name := range spec.Names {
+								report(name, decl.Tok.String())
+							}
+						}
+					}
 				}
 			}
-		*/
+		}
+	}
+	if n > maxDocCommentDiagnostics {
+		pass.Reportf(morePos, SeverityInfo, "%d more exported declarations should have a comment", n-maxDocCommentDiagnostics)
+	}
+}
 
-		v := vetVisitor{importPaths: b.fileImportPaths(fname), errors: errors}
-		ast.Walk(&v, file)
+// predeclared is the set of predeclared identifiers.
+var predeclared = map[string]bool{
+	"bool": true, "byte": true, "complex64": true, "complex128": true,
+	"error": true, "float32": true, "float64": true, "int": true,
+	"int8": true, "int16": true, "int32": true, "int64": true, "rune": true,
+	"string": true, "uint": true, "uint8": true, "uint16": true,
+	"uint32": true, "uint64": true, "uintptr": true, "true": true,
+	"false": true, "iota": true, "nil": true, "append": true, "cap": true,
+	"close": true, "complex": true, "copy": true, "delete": true,
+	"imag": true, "len": true, "make": true, "new": true, "panic": true,
+	"print": true, "println": true, "real": true, "recover": true,
+}
+
+// checkShadowedNames finds top-level declarations that shadow predeclared
+// identifiers and imports that shadow the names of standard packages imported
+// by other files in the package.
+func checkShadowedNames(pass *Pass) {
+	stdNames := make(map[string]string)
+	for _, fname := range pass.FileNames() {
+		for name, importPath := range pass.FileImportPaths(fname) {
+			if isStandardPackage(importPath) && path.Base(importPath) == name {
+				stdNames[name] = importPath
+			}
+		}
 	}
-	for message, pos := range errors {
-		b.pkg.Errors = append(b.pkg.Errors,
-			fmt.Sprintf("%s (%s)", message, b.fset.Position(pos)))
+	for _, fname := range pass.FileNames() {
+		file := pass.Package.Files[fname]
+		for _, is := range file.Imports {
+			if is.Name == nil {
+				continue
+			}
+			importPath, _ := strconv.Unquote(is.Path.Value)
+			if stdImportPath := stdNames[is.Name.Name]; stdImportPath != "" && stdImportPath != importPath {
+				pass.Reportf(is.Pos(), SeverityWarning, "import name %s for %q shadows standard package %q", is.Name.Name, importPath, stdImportPath)
+			}
+		}
+		for _, decl := range file.Decls {
+			var names []*ast.Ident
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					names = append(names, decl.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						names = append(names, spec.Name)
+					case *ast.ValueSpec:
+						names = append(names, spec.Names...)
+					}
+				}
+			}
+			for _, name := range names {
+				if predeclared[name.Name] {
+					pass.Reportf(name.Pos(), SeverityWarning, "declaration of %s shadows predeclared identifier", name.Name)
+				}
+			}
+		}
 	}
 }
@@ -0,0 +1,286 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build linux,!appengine
+
+package run
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// LocalRunner compiles programs with the local Go toolchain and runs them
+// with resource limits in new network, mount, PID and IPC namespaces. The
+// program's root directory contains only the program binary, and the program
+// runs as an unprivileged user in a user namespace. If the runner is started
+// as root, programs run as the nobody user (65534). Otherwise, programs run
+// as the runner's user, so the runner should be started as a dedicated user.
+type LocalRunner struct {
+	// Path of the go command. The default is "go" found in $PATH.
+	GoCmd string
+
+	// Additional environment for the go command, for example GOPATH,
+	// GOPROXY and GOCACHE settings.
+	Env []string
+
+	// Wall clock limit for fetching dependencies and compiling the program.
+	// The default is one minute.
+	BuildTimeout time.Duration
+
+	// Wall clock and CPU time limits for running the program. The defaults
+	// are five seconds and two seconds.
+	Timeout time.Duration
+	CPUTime time.Duration
+
+	// Data segment limit for the program in bytes. The default is 256MB.
+	Memory int64
+
+	// Maximum number of processes and threads for the program. The default
+	// is 64.
+	MaxProcs int
+
+	// Maximum number of output bytes kept from the program. The default is
+	// 64KB.
+	MaxOutput int
+}
+
+func (lr *LocalRunner) goCmd() string {
+	if lr.GoCmd == "" {
+		return "go"
+	}
+	return lr.GoCmd
+}
+
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// limitedBuffer is a buffer that silently discards writes past n bytes.
+type limitedBuffer struct {
+	bytes.Buffer
+	n int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.Len()+len(p) > b.n {
+		p = p[:b.n-b.Len()]
+	}
+	b.Buffer.Write(p)
+	return n, nil
+}
+
+func (lr *LocalRunner) Run(src string) (*Result, error) {
+	dir, err := ioutil.TempDir("", "run")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "prog.go"), []byte(src), 0644); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module prog\n"), 0644); err != nil {
+		return nil, err
+	}
+
+	// Build the program. The build is allowed to use the network to fetch
+	// the packages imported by the program.
+
+	ctx, cancel := context.WithTimeout(context.Background(), durationOrDefault(lr.BuildTimeout, time.Minute))
+	defer cancel()
+	for _, args := range [][]string{{"mod", "tidy"}, {"build", "-o", "prog", "."}} {
+		cmd := exec.CommandContext(ctx, lr.goCmd(), args...)
+		cmd.Dir = dir
+		cmd.Env = append(append(os.Environ(), "GO111MODULE=on", "CGO_ENABLED=0"), lr.Env...)
+		if p, err := cmd.CombinedOutput(); err != nil {
+			if ctx.Err() != nil {
+				return &Result{Errors: "Timeout building program."}, nil
+			}
+			return &Result{Errors: string(p)}, nil
+		}
+	}
+
+	// Run the program. The program is statically linked because CGO is
+	// disabled, so the root directory of the program only needs to contain
+	// the program.
+
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0711); err != nil {
+		return nil, err
+	}
+	defer os.Chmod(root, 0700)
+	if err := os.Rename(filepath.Join(dir, "prog"), filepath.Join(root, "prog")); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(filepath.Join(root, "prog"), 0555); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(root, 0555); err != nil {
+		return nil, err
+	}
+
+	maxOutput := lr.MaxOutput
+	if maxOutput <= 0 {
+		maxOutput = 64 * 1024
+	}
+	memory := lr.Memory
+	if memory <= 0 {
+		memory = 256 * 1024 * 1024
+	}
+	maxProcs := lr.MaxProcs
+	if maxProcs <= 0 {
+		maxProcs = 64
+	}
+	cpuTime := durationOrDefault(lr.CPUTime, 2*time.Second)
+
+	hostUID, hostGID := os.Getuid(), os.Getgid()
+	if hostUID == 0 {
+		hostUID, hostGID = nobodyID, nobodyID
+	}
+
+	cmd := exec.Command("/prog")
+	cmd.Dir = "/"
+	cmd.Env = []string{"HOME=/"}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		// A new network namespace has no interfaces except an unconfigured
+		// loopback device. The user namespace allows an unprivileged
+		// process to create the namespaces and to change the root
+		// directory. The program runs as a user other than root in the user
+		// namespace, so the program has no capabilities after exec.
+		Cloneflags: syscall.CLONE_NEWNET | syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS |
+			syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: nobodyID, HostID: hostUID, Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: nobodyID, HostID: hostGID, Size: 1}},
+		Chroot:      root,
+		Credential:  &syscall.Credential{Uid: nobodyID, Gid: nobodyID, NoSetGroups: true},
+
+		// The program and its children are killed as a group.
+		Setpgid: true,
+	}
+	output := &limitedBuffer{n: maxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err = startLimited(cmd, []rlimit{
+		{syscall.RLIMIT_CPU, uint64((cpuTime + time.Second - 1) / time.Second)},
+		{syscall.RLIMIT_DATA, uint64(memory)},
+		{rlimitNPROC, uint64(maxProcs)},
+		{syscall.RLIMIT_FSIZE, 0},
+		{syscall.RLIMIT_CORE, 0},
+	})
+	if err != nil {
+		return nil, err
+	}
+	pid := cmd.Process.Pid
+	timer := time.AfterFunc(durationOrDefault(lr.Timeout, 5*time.Second), func() {
+		syscall.Kill(-pid, syscall.SIGKILL)
+	})
+	err = cmd.Wait()
+	timedOut := !timer.Stop()
+
+	// Kill children that outlive the program. The kernel also kills the
+	// processes in the PID namespace when the program exits.
+	syscall.Kill(-pid, syscall.SIGKILL)
+
+	result := &Result{}
+	if err != nil {
+		switch {
+		case timedOut:
+			result.Errors = "Program timed out."
+		case isStartError(err):
+			return nil, err
+		default:
+			result.Errors = "Program exited: " + err.Error()
+		}
+	}
+	result.Output = output.String()
+	return result, nil
+}
+
+// nobodyID is the user and group ID of the program in the user namespace.
+const nobodyID = 65534
+
+// rlimitNPROC is RLIMIT_NPROC on the common Linux architectures. The syscall
+// package does not define the constant.
+const rlimitNPROC = 6
+
+type rlimit struct {
+	resource int
+	value    uint64
+}
+
+// startLimited starts cmd with the resource limits. The process is stopped
+// at exec while the limits are set so that the program cannot start other
+// processes before the limits apply.
+func startLimited(cmd *exec.Cmd, limits []rlimit) error {
+	// The thread that starts a traced process must also detach it.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd.SysProcAttr.Ptrace = true
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	var ws syscall.WaitStatus
+	_, err := syscall.Wait4(pid, &ws, 0, nil)
+	if err == nil && !ws.Stopped() {
+		err = errors.New("run: program did not stop at exec")
+	}
+	for _, l := range limits {
+		if err != nil {
+			break
+		}
+		err = prlimit(pid, l.resource, l.value)
+	}
+	if err == nil {
+		err = syscall.PtraceDetach(pid)
+	}
+	if err != nil {
+		syscall.Kill(pid, syscall.SIGKILL)
+		cmd.Wait()
+		return err
+	}
+	return nil
+}
+
+func prlimit(pid int, resource int, value uint64) error {
+	lim := syscall.Rlimit{Cur: value, Max: value}
+	_, _, e := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&lim)), 0, 0, 0)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// isStartError returns true if err is from starting the command instead of
+// from running the command.
+func isStartError(err error) bool {
+	_, ok := err.(*exec.ExitError)
+	return !ok
+}
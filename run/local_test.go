@@ -0,0 +1,232 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build linux,!appengine
+
+package run
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRunner returns a local runner. The test is skipped if the go
+// command is not found or if the sandbox cannot be created on the host.
+func newTestRunner(t *testing.T) *LocalRunner {
+	if testing.Short() {
+		t.Skip("skipping sandbox test in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	lr := &LocalRunner{Timeout: 3 * time.Second, Env: []string{"GOFLAGS=-mod=mod", "GOPROXY=off"}}
+	result, err := lr.Run("package main\n\nfunc main() { println(\"hello\") }\n")
+	if err != nil {
+		t.Skipf("sandbox not supported: %v", err)
+	}
+	if result.Errors != "" || strings.TrimSpace(result.Output) != "hello" {
+		t.Fatalf("Run(hello) = %+v", result)
+	}
+	return lr
+}
+
+func runTest(t *testing.T, lr *LocalRunner, src string) *Result {
+	result, err := lr.Run(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result.Errors, ".go:") {
+		t.Fatalf("build failed: %s", result.Errors)
+	}
+	return result
+}
+
+func TestLocalRunnerNetwork(t *testing.T) {
+	lr := newTestRunner(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Write([]byte("connected\n"))
+			c.Close()
+		}
+	}()
+
+	result := runTest(t, lr, `package main
+
+import (
+	"fmt"
+	"net"
+)
+
+func main() {
+	c, err := net.Dial("tcp", "`+ln.Addr().String()+`")
+	if err == nil {
+		c.Close()
+		fmt.Println("connected")
+		return
+	}
+	fmt.Println("refused")
+}
+`)
+	if strings.TrimSpace(result.Output) != "refused" {
+		t.Errorf("program connected to the host: %+v", result)
+	}
+}
+
+func TestLocalRunnerFilesystem(t *testing.T) {
+	lr := newTestRunner(t)
+	dir, err := ioutil.TempDir("", "runtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := runTest(t, lr, `package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	for _, name := range []string{"/etc/passwd", "`+secret+`", "../../../etc/passwd"} {
+		if _, err := ioutil.ReadFile(name); err == nil {
+			fmt.Println("read", name)
+		}
+	}
+	for _, name := range []string{"/x", "/tmp/x", "/prog"} {
+		if err := ioutil.WriteFile(name, []byte("x"), 0644); err == nil {
+			fmt.Println("wrote", name)
+		}
+	}
+	if err := os.Chdir(".."); err == nil {
+		if wd, _ := os.Getwd(); wd != "/" {
+			fmt.Println("escaped to", wd)
+		}
+	}
+	fmt.Println("done")
+}
+`)
+	if strings.TrimSpace(result.Output) != "done" {
+		t.Errorf("program escaped the sandbox: %+v", result)
+	}
+}
+
+func TestLocalRunnerForkBomb(t *testing.T) {
+	lr := newTestRunner(t)
+	lr.MaxProcs = 16
+	start := time.Now()
+	result := runTest(t, lr, `package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func main() {
+	n := 0
+	for i := 0; i < 1000; i++ {
+		pid, _, e := syscall.RawSyscall(syscall.SYS_FORK, 0, 0, 0)
+		if e != 0 {
+			break
+		}
+		if pid == 0 {
+			for {
+				syscall.RawSyscall(syscall.SYS_SCHED_YIELD, 0, 0, 0)
+			}
+		}
+		n++
+	}
+	fmt.Println(n)
+}
+`)
+	if d := time.Since(start); d > 30*time.Second {
+		t.Errorf("fork bomb ran for %v", d)
+	}
+	var n int
+	if _, err := fmt.Sscan(result.Output, &n); err != nil || n >= lr.MaxProcs {
+		t.Errorf("program started %q processes, want fewer than %d", strings.TrimSpace(result.Output), lr.MaxProcs)
+	}
+}
+
+func TestLocalRunnerTimeout(t *testing.T) {
+	lr := newTestRunner(t)
+	lr.Timeout = time.Second
+	const marker = "runtest-timeout-child"
+	start := time.Now()
+	result := runTest(t, lr, `package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+func main() {
+	if len(os.Args) == 1 {
+		// Start a child that outlives the program.
+		syscall.ForkExec("/prog", []string{"/prog", "`+marker+`"}, &syscall.ProcAttr{Files: []uintptr{0, 1, 2}})
+	} else {
+		fmt.Println("child")
+	}
+	time.Sleep(time.Hour)
+}
+`)
+	if d := time.Since(start); d > 20*time.Second {
+		t.Errorf("Run took %v, want return after timeout", d)
+	}
+	if result.Errors != "Program timed out." || strings.TrimSpace(result.Output) != "child" {
+		t.Errorf("Run() = %+v, want timeout with output from child", result)
+	}
+
+	// The kernel removes the killed processes asynchronously.
+	for i := 0; ; i++ {
+		matches, _ := filepath.Glob("/proc/[0-9]*/cmdline")
+		alive := false
+		for _, name := range matches {
+			p, _ := ioutil.ReadFile(name)
+			if strings.Contains(string(p), marker) {
+				alive = true
+			}
+		}
+		if !alive {
+			break
+		}
+		if i == 50 {
+			t.Fatal("child process is running after timeout")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package run runs example programs.
+package run
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Result is the result of running a program.
+type Result struct {
+	// Output written by the program to stdout and stderr.
+	Output string
+
+	// Build errors or the reason that the program failed. Errors is "" if
+	// the program ran to completion with exit status 0.
+	Errors string
+}
+
+// Runner is implemented by example execution backends.
+type Runner interface {
+	// Run compiles and runs the Go program src. The returned error is for
+	// failures of the backend itself. Failures of the program are reported
+	// in Result.Errors.
+	Run(src string) (*Result, error)
+}
+
+// OutputMatches returns true if the program output matches the expected
+// output using the same rules as "go test".
+func OutputMatches(output, want string) bool {
+	return strings.TrimSpace(output) == strings.TrimSpace(want)
+}
+
+// maxSourceSize is the maximum size of a program accepted by Handler.
+const maxSourceSize = 64 * 1024
+
+// Handler returns an HTTP handler that runs the program in the body of POST
+// requests with runner. The result is encoded as JSON. If token is not "",
+// requests must have the header "Authorization: Bearer " + token.
+func Handler(runner Runner, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not supported.", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		src, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSourceSize))
+		if err != nil {
+			http.Error(w, "Program too large.", http.StatusRequestEntityTooLarge)
+			return
+		}
+		result, err := runner.Run(string(src))
+		if err != nil {
+			http.Error(w, "Internal Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// Client is a Runner that sends programs to a Handler on a remote server.
+type Client struct {
+	// URL of the remote handler.
+	URL string
+
+	// HTTP client for requests to the remote handler.
+	HTTPClient *http.Client
+
+	// Token sent to the remote handler or "" if the handler does not
+	// require a token.
+	Token string
+}
+
+var errNoURL = errors.New("run: no URL configured for runner")
+
+func (c *Client) Run(src string) (*Result, error) {
+	if c.URL == "" {
+		return nil, errNoURL
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader([]byte(src)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("run: post %s -> %d", c.URL, resp.StatusCode)
+	}
+	var result Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package run
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+type echoRunner struct{}
+
+func (echoRunner) Run(src string) (*Result, error) {
+	return &Result{Output: src}, nil
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(Handler(echoRunner{}, "secret"))
+	defer server.Close()
+
+	c := &Client{URL: server.URL, Token: "secret"}
+	const src = "package main\n\nfunc main() {}\n"
+	result, err := c.Run(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Output != src || result.Errors != "" {
+		t.Errorf("Run() = %+v, want output %q", result, src)
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		c := &Client{URL: server.URL, Token: token}
+		if _, err := c.Run(src); err == nil {
+			t.Errorf("Run() with token %q returned nil error", token)
+		}
+	}
+}
+
+var outputMatchesTests = []struct {
+	output, want string
+	matches      bool
+}{
+	{"hello\n", "hello", true},
+	{"  hello\n\n", "\nhello", true},
+	{"hello\n", "world", false},
+	{"", "", true},
+}
+
+func TestOutputMatches(t *testing.T) {
+	for _, tt := range outputMatchesTests {
+		if matches := OutputMatches(tt.output, tt.want); matches != tt.matches {
+			t.Errorf("OutputMatches(%q, %q) = %v, want %v", tt.output, tt.want, matches, tt.matches)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2012 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// +build ignore
+
+// Command runserver serves a local example runner for GoPkgDoc. The runner
+// runs arbitrary programs, so requests must have the shared token set with
+// the -token flag or the RUN_TOKEN environment variable. The server listens on
+// the loopback interface by default.
+//
+// Usage: go run runserver.go -token secret [-addr 127.0.0.1:8081]
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"run"
+	"time"
+)
+
+var (
+	addr    = flag.String("addr", "127.0.0.1:8081", "Listen address")
+	token   = flag.String("token", os.Getenv("RUN_TOKEN"), "Token required in the Authorization header of requests")
+	timeout = flag.Duration("timeout", 5*time.Second, "Wall clock limit for running a program")
+	cpuTime = flag.Duration("cpu", 2*time.Second, "CPU time limit for running a program")
+	memory  = flag.Int64("memory", 256*1024*1024, "Memory limit in bytes for running a program")
+	procs   = flag.Int("procs", 64, "Limit on processes and threads for running a program")
+)
+
+func main() {
+	flag.Parse()
+	if *token == "" {
+		log.Fatal("runserver: set a token with -token or RUN_TOKEN")
+	}
+	runner := &run.LocalRunner{
+		Timeout:  *timeout,
+		CPUTime:  *cpuTime,
+		Memory:   *memory,
+		MaxProcs: *procs,
+	}
+	log.Fatal(http.ListenAndServe(*addr, run.Handler(runner, *token)))
+}